@@ -2,121 +2,220 @@ package layout
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"html/template"
 	"io"
-	"io/ioutil"
+	"io/fs"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Option struct {
 	Debug      bool             // Debug mode or not
 	Folder     string           // Base folder for layout and html files, default is 'views'
+	FS         fs.FS            // File system which contains 'Folder', e.g. an embed.FS, nil or Debug mode means the OS one
+	Partials   string           // Folder of partials, relative to 'Folder', default is 'partials'
 	Ext        string           // File extension of layout and html, default is '.html'
 	LeftDelim  string           // Left delimiter of template action, default is '{{'
 	RightDelim string           // Right delimiter of template action, default is '}}'
 	Funcs      template.FuncMap // Function map for template
+
+	// Watch mode, the files under 'Folder' are checked every 'WatchInterval',
+	// and the affected pages are recompiled when a file changes
+	Watch         bool
+	WatchInterval time.Duration // default is 1 second
+	OnError       func(e error) // called when a page fails to compile or render
+
+	// Page rendered by Render on failure, with an *ErrorData, optional
+	ErrorPage string
 }
 
 type tmplmap map[string]*template.Template
 
+// source is the text of a page or a layout
+type source struct {
+	layout string // name of the layout it uses, empty if none
+	text   string
+}
+
+// sources are the layouts and partials shared by the pages
+type sources struct {
+	layouts  map[string]*source
+	partials map[string]string // name, like 'partials/header' => text
+}
+
+// page is the information of a page used by the watcher
+type page struct {
+	path    string
+	layouts []string // names of the layouts used by the page, the nearest first
+	failed  bool     // the page failed to compile, it is retried on every change
+}
+
 type Layout struct {
 	Option
-	pages tmplmap
+	fsys  fs.FS        // file system rooted at 'Folder', paths in it are separated by '/'
+	pages atomic.Value // tmplmap, replaced as a whole in watch mode
+
+	// states of the watcher, only accessed by Build and the watcher
+	sources *sources
+	deps    map[string]*page
+	stats   map[string]fileStat
+	stop    chan struct{}
+	wg      sync.WaitGroup
 }
 
-func (l *Layout) Render(w http.ResponseWriter, name string, data interface{}) {
-	defer func() {
-		if e := recover(); e != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-	}()
+func (l *Layout) reportError(e error) {
+	if l.OnError != nil {
+		l.OnError(e)
+	}
+}
+
+// ErrNotFound is returned by Execute if the page does not exist
+var ErrNotFound = errors.New("page not found")
+
+// ErrorData is the data of the error page
+type ErrorData struct {
+	Status int    // HTTP status code
+	Page   string // name of the page which failed
+	Error  error
+}
+
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
 
-	var t *template.Template
+func getBuffer() *bytes.Buffer {
+	return bufPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	// do not keep too large buffers
+	if buf.Cap() <= 1<<20 {
+		buf.Reset()
+		bufPool.Put(buf)
+	}
+}
 
+// lookup returns the template of page 'name'
+func (l *Layout) lookup(name string) (*template.Template, error) {
 	if l.Debug {
 		path := name
 		if !strings.HasSuffix(name, l.Ext) {
 			path += l.Ext
 		}
-		t = l.loadPage(path, nil)
-	} else {
-		if strings.HasSuffix(name, l.Ext) {
-			name = name[:len(name)-len(l.Ext)]
+		t, e := l.loadDebugPage(path)
+		if errors.Is(e, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
 		}
-		t = l.pages[name]
+		return t, e
 	}
 
-	if t == nil {
-		w.WriteHeader(http.StatusNotFound)
-	} else if e := t.ExecuteTemplate(w, "", data); e != nil {
-		panic(e)
+	if strings.HasSuffix(name, l.Ext) {
+		name = name[:len(name)-len(l.Ext)]
+	}
+	pages, _ := l.pages.Load().(tmplmap)
+	if t := pages[name]; t != nil {
+		return t, nil
 	}
+	return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
 }
 
-func (l *Layout) getFileList(folder string) []string {
-	var files []string
-
-	dir, e := os.Open(folder)
+// execute renders page 'name' into 'buf'
+func (l *Layout) execute(buf *bytes.Buffer, name string, data interface{}) error {
+	t, e := l.lookup(name)
 	if e != nil {
-		return nil
+		return e
 	}
-	defer dir.Close()
-
-	fis, e := dir.Readdir(-1)
-	if e != nil {
-		return nil
+	if e = t.Execute(buf, data); e != nil {
+		return fmt.Errorf("%s: %w", name, e)
 	}
+	return nil
+}
 
-	for _, fi := range fis {
-		path := filepath.Join(folder, fi.Name())
-		if fi.IsDir() {
-			list := l.getFileList(path)
-			files = append(files, list...)
-			continue
-		}
-		if !strings.HasSuffix(path, l.Ext) {
-			continue
-		}
-		if path, e = filepath.Rel(l.Folder, path); e == nil {
-			files = append(files, path)
-		}
+// Execute renders page 'name' with 'data' to 'w', the page is rendered
+// into a buffer first, so nothing is written to 'w' if it fails
+func (l *Layout) Execute(w io.Writer, name string, data interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if e := l.execute(buf, name, data); e != nil {
+		return e
 	}
+	_, e := buf.WriteTo(w)
+	return e
+}
 
-	return files
+// Render renders page 'name' with 'data' as the response, see RenderStatus
+func (l *Layout) Render(w http.ResponseWriter, name string, data interface{}) {
+	l.RenderStatus(w, http.StatusOK, name, data)
 }
 
-func (l *Layout) newTemplate() *template.Template {
-	return template.New("").Delims(l.LeftDelim, l.RightDelim).Funcs(l.Funcs)
+// RenderStatus renders page 'name' with 'data' as the response, with status
+// code 'status'. If the page does not exist, or fails to render, the error
+// page is rendered with an *ErrorData, and status 404 or 500.
+func (l *Layout) RenderStatus(w http.ResponseWriter, status int, name string, data interface{}) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if e := l.execute(buf, name, data); e != nil {
+		status = http.StatusNotFound
+		if !errors.Is(e, ErrNotFound) {
+			status = http.StatusInternalServerError
+			l.reportError(e)
+		}
+		l.renderError(w, &ErrorData{Status: status, Page: name, Error: e})
+		return
+	}
+
+	writeHTML(w, status, buf)
 }
 
-func (l *Layout) loadLayout(path string) *template.Template {
-	path = filepath.Join(l.Folder, path)
-	data, e := ioutil.ReadFile(path)
-	if e != nil {
-		panic(e)
+// renderError renders the error page, or a plain text error if there's no
+// error page or it fails
+func (l *Layout) renderError(w http.ResponseWriter, ed *ErrorData) {
+	if len(l.ErrorPage) > 0 && ed.Page != l.ErrorPage {
+		buf := getBuffer()
+		defer putBuffer(buf)
+		if e := l.execute(buf, l.ErrorPage, ed); e == nil {
+			writeHTML(w, ed.Status, buf)
+			return
+		} else if !errors.Is(e, ErrNotFound) {
+			l.reportError(e)
+		}
 	}
+	http.Error(w, http.StatusText(ed.Status), ed.Status)
+}
 
-	t, e := l.newTemplate().Parse(string(data))
-	if e != nil {
-		panic(e)
+func writeHTML(w http.ResponseWriter, status int, buf *bytes.Buffer) {
+	h := w.Header()
+	if len(h.Get("Content-Type")) == 0 {
+		h.Set("Content-Type", "text/html; charset=utf-8")
 	}
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
 
-	return t
+func (l *Layout) newTemplate() *template.Template {
+	return template.New("").Delims(l.LeftDelim, l.RightDelim).Funcs(l.Funcs)
 }
 
 func (l *Layout) getLayoutName(data []byte) string {
 	prefix, suffix := l.LeftDelim+"/*", "*/"+l.RightDelim
 	buf := bytes.NewBuffer(data)
 	for {
+		// bytes.Buffer only fails at the end of the data
 		line, e := buf.ReadString('\n')
-		if e != nil {
-			if e == io.EOF {
-				break
-			}
-			panic(e)
+		if e != nil && len(line) == 0 {
+			break
 		}
 
 		line = strings.TrimSpace(line)
@@ -148,34 +247,147 @@ func (l *Layout) getLayoutName(data []byte) string {
 	return ""
 }
 
-func (l *Layout) loadPage(path string, layouts tmplmap) *template.Template {
-	path = filepath.Join(l.Folder, path)
-	data, e := ioutil.ReadFile(path)
+// readSource reads the file at 'path', which is relative to 'Folder'
+func (l *Layout) readSource(path string) (*source, error) {
+	data, e := fs.ReadFile(l.fsys, path)
 	if e != nil {
-		panic(e)
+		return nil, e
 	}
+	return &source{layout: l.getLayoutName(data), text: string(data)}, nil
+}
 
-	var t *template.Template
+type fileKind int
 
-	ln := l.getLayoutName(data)
-	if len(ln) == 0 {
-		t = l.newTemplate()
-	} else if layouts == nil {
-		t = l.loadLayout(ln + "_layout" + l.Ext)
-	} else if ll := layouts[ln]; ll != nil {
-		t, _ = ll.Clone()
-	} else {
-		panic("cannot find Layout: " + ln)
+const (
+	pageFile fileKind = iota
+	layoutFile
+	partialFile
+)
+
+// classify returns the kind and the name of the file at 'path'
+func (l *Layout) classify(path string) (fileKind, string) {
+	name := path[:len(path)-len(l.Ext)]
+	if strings.HasPrefix(name, l.Partials+"/") {
+		return partialFile, name
+	}
+	if strings.HasSuffix(name, "_layout") {
+		return layoutFile, name[:len(name)-len("_layout")]
+	}
+	return pageFile, name
+}
+
+// readSources reads the layouts and partials in 'files'
+func (l *Layout) readSources(files []string) (*sources, error) {
+	srcs := &sources{layouts: make(map[string]*source), partials: make(map[string]string)}
+	for _, f := range files {
+		kind, name := l.classify(f)
+		if kind == pageFile {
+			continue
+		}
+		src, e := l.readSource(f)
+		if e != nil {
+			return nil, e
+		}
+		if kind == layoutFile {
+			srcs.layouts[name] = src
+		} else {
+			srcs.partials[name] = src.text
+		}
+	}
+	return srcs, nil
+}
+
+// compile compiles page 'path' with the layouts it uses and the partials,
+// it also returns the names of the layouts, the nearest first
+func (l *Layout) compile(path string, srcs *sources) (*template.Template, []string, error) {
+	src, e := l.readSource(path)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	chain := []*source{src}
+	var names []string
+	for ln := src.layout; len(ln) > 0; ln = chain[len(chain)-1].layout {
+		for _, n := range names {
+			if n == ln {
+				return nil, nil, fmt.Errorf("%s: layout cycle: %s -> %s", path, strings.Join(names, " -> "), ln)
+			}
+		}
+		user := path
+		if len(names) > 0 {
+			user = names[len(names)-1] + "_layout" + l.Ext
+		}
+		names = append(names, ln)
+		src := srcs.layouts[ln]
+		if src == nil {
+			return nil, nil, fmt.Errorf("%s: layout '%s' is not found", user, ln)
+		}
+		chain = append(chain, src)
 	}
 
-	if _, e = t.Parse(string(data)); e != nil {
-		panic(e)
+	t := l.newTemplate()
+	for name, text := range srcs.partials {
+		if _, e := t.New(name).Parse(text); e != nil {
+			return nil, nil, fmt.Errorf("partial '%s': %v", name, e)
+		}
+	}
+	// parse from the outermost layout, so that its body is the body of the
+	// page, and the inner ones only define templates
+	for i := len(chain) - 1; i >= 0; i-- {
+		if _, e := t.Parse(chain[i].text); e != nil {
+			name := path
+			if i > 0 {
+				name = names[i-1] + "_layout" + l.Ext
+			}
+			return nil, nil, fmt.Errorf("%s: %v", name, e)
+		}
 	}
 
-	return t
+	return t, names, nil
 }
 
-func (l *Layout) Build(o Option) {
+// loadDebugPage reads and compiles page 'path' with the current files, only
+// the page, the layouts it uses and the partials are read
+func (l *Layout) loadDebugPage(path string) (*template.Template, error) {
+	if kind, _ := l.classify(path); kind != pageFile {
+		return nil, fs.ErrNotExist
+	}
+
+	var partials []string
+	fs.WalkDir(l.fsys, l.Partials, func(path string, d fs.DirEntry, e error) error {
+		if e == nil && !d.IsDir() && strings.HasSuffix(path, l.Ext) {
+			partials = append(partials, path)
+		}
+		return nil
+	})
+	srcs, e := l.readSources(partials)
+	if e != nil {
+		return nil, e
+	}
+
+	src, e := l.readSource(path)
+	if e != nil {
+		return nil, e
+	}
+	// a missing layout or a cycle is reported by compile
+	for ln := src.layout; len(ln) > 0 && srcs.layouts[ln] == nil; ln = src.layout {
+		if src, e = l.readSource(ln + "_layout" + l.Ext); errors.Is(e, fs.ErrNotExist) {
+			break
+		} else if e != nil {
+			return nil, e
+		}
+		srcs.layouts[ln] = src
+	}
+
+	t, _, e := l.compile(path, srcs)
+	return t, e
+}
+
+// Build compiles the pages under 'o.Folder', it returns the first error,
+// like a syntax error or a missing layout. The pages which compile are
+// served even if it fails, and the failed ones are retried by the watcher.
+func (l *Layout) Build(o Option) error {
+	l.Close()
 	l.Option = o
 
 	if len(l.Folder) == 0 {
@@ -184,6 +396,22 @@ func (l *Layout) Build(o Option) {
 		l.Folder = filepath.Clean(l.Folder)
 	}
 
+	// 'Folder' on the OS file system is used in debug mode, so that the
+	// changes are seen without rebuilding the binary
+	if l.FS == nil || l.Debug {
+		l.fsys = os.DirFS(l.Folder)
+	} else if sub, e := fs.Sub(l.FS, filepath.ToSlash(l.Folder)); e != nil {
+		return e
+	} else {
+		l.fsys = sub
+	}
+
+	if len(l.Partials) == 0 {
+		l.Partials = "partials"
+	} else {
+		l.Partials = strings.Trim(path.Clean(filepath.ToSlash(l.Partials)), "/")
+	}
+
 	if len(l.Ext) == 0 {
 		l.Ext = ".html"
 	} else if l.Ext[0] != '.' {
@@ -197,26 +425,42 @@ func (l *Layout) Build(o Option) {
 		l.RightDelim = "}}"
 	}
 
-	files := l.getFileList(l.Folder)
+	l.stats = l.scan()
+	files := make([]string, 0, len(l.stats))
+	for f := range l.stats {
+		files = append(files, f)
+	}
+	sort.Strings(files)
 
-	suffix := "_layout" + l.Ext
-	layouts := make(tmplmap)
-	for _, f := range files {
-		if !strings.HasSuffix(f, suffix) {
-			continue
-		}
-		name := f[:len(f)-len(suffix)]
-		name = strings.Replace(name, "\\", "/", -1)
-		layouts[name] = l.loadLayout(f)
+	srcs, e := l.readSources(files)
+	if e != nil {
+		return e
 	}
+	l.sources = srcs
 
-	l.pages = make(tmplmap)
+	var failure error
+	pages := make(tmplmap)
+	l.deps = make(map[string]*page)
 	for _, f := range files {
-		if strings.HasSuffix(f, suffix) {
+		kind, name := l.classify(f)
+		if kind != pageFile {
 			continue
 		}
-		name := f[:len(f)-len(l.Ext)]
-		name = strings.Replace(name, "\\", "/", -1)
-		l.pages[name] = l.loadPage(f, layouts)
+		t, layouts, e := l.compile(f, srcs)
+		if e != nil {
+			if failure == nil {
+				failure = e
+			}
+			l.deps[name] = &page{path: f, failed: true}
+			continue
+		}
+		pages[name] = t
+		l.deps[name] = &page{path: f, layouts: layouts}
+	}
+	l.pages.Store(pages)
+
+	if l.Watch {
+		l.startWatch()
 	}
+	return failure
 }
@@ -0,0 +1,236 @@
+package layout
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// writeFile writes a file with a rename, so that the watcher never sees a
+// partially written file
+func writeFile(t *testing.T, dir, name, content string) {
+	path := filepath.Join(dir, name)
+	if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(path+".tmp", []byte(content), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Rename(path+".tmp", path); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func render(l *Layout, name string, data interface{}) string {
+	w := httptest.NewRecorder()
+	l.Render(w, name, data)
+	return w.Body.String()
+}
+
+// waitFor renders 'name' until the output is 'expect'
+func waitFor(t *testing.T, l *Layout, name, expect string) {
+	var got string
+	for i := 0; i < 200; i++ {
+		if got = render(l, name, "x"); got == expect {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("%s: expect '%s', got '%s'", name, expect, got)
+}
+
+func Test_Watch(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "main_layout.html", `<main>{{template "body" .}}</main>`)
+	writeFile(t, dir, "index.html", "{{/* layout: main */}}\n{{define \"body\"}}index {{.}}{{end}}")
+	writeFile(t, dir, "sub/about.html", "about {{.}}")
+
+	var l Layout
+	if e := l.Build(Option{Folder: dir, Watch: true, WatchInterval: 5 * time.Millisecond}); e != nil {
+		t.Fatal(e)
+	}
+	defer l.Close()
+
+	if s := render(&l, "index", "x"); s != "<main>index x</main>" {
+		t.Fatalf("unexpected output '%s'", s)
+	}
+
+	writeFile(t, dir, "main_layout.html", `<section>{{template "body" .}}</section>`)
+	waitFor(t, &l, "index", "<section>index x</section>")
+
+	writeFile(t, dir, "sub/about.html", "about us {{.}}")
+	waitFor(t, &l, "sub/about", "about us x")
+
+	// a broken page keeps the old version
+	writeFile(t, dir, "sub/about.html", "about {{.")
+	time.Sleep(50 * time.Millisecond)
+	if s := render(&l, "sub/about", "x"); s != "about us x" {
+		t.Errorf("unexpected output '%s'", s)
+	}
+
+	writeFile(t, dir, "new.html", "new {{.}}")
+	waitFor(t, &l, "new", "new x")
+	os.Remove(filepath.Join(dir, "new.html"))
+	waitFor(t, &l, "new", "Not Found\n")
+
+	// a page which fails to compile is compiled again when its layout is
+	// created
+	writeFile(t, dir, "p.html", "{{/* layout: nl */}}\n{{define \"body\"}}p {{.}}{{end}}")
+	time.Sleep(50 * time.Millisecond)
+	if s := render(&l, "p", "x"); s != "Not Found\n" {
+		t.Errorf("unexpected output '%s'", s)
+	}
+	writeFile(t, dir, "nl_layout.html", `<nl>{{template "body" .}}</nl>`)
+	waitFor(t, &l, "p", "<nl>p x</nl>")
+}
+
+func Test_NestedLayouts(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "base_layout.html", `<html>{{template "partials/header" .}}{{template "main" .}}</html>`)
+	writeFile(t, dir, "admin/admin_layout.html", "{{/* layout: base */}}\n{{define \"main\"}}<nav/>{{template \"body\" .}}{{end}}")
+	writeFile(t, dir, "admin/users.html", "{{/* layout: admin/admin */}}\n{{define \"body\"}}users {{.}}{{end}}")
+	writeFile(t, dir, "partials/header.html", `<h1>{{.}}</h1>`)
+
+	var l Layout
+	if e := l.Build(Option{Folder: dir}); e != nil {
+		t.Fatal(e)
+	}
+	if s := render(&l, "admin/users", "x"); s != "<html><h1>x</h1><nav/>users x</html>" {
+		t.Errorf("unexpected output '%s'", s)
+	}
+	if s := render(&l, "partials/header", "x"); s != "Not Found\n" {
+		t.Errorf("partials should not be pages, got '%s'", s)
+	}
+
+	writeFile(t, dir, "base_layout.html", "{{/* layout: admin/admin */}}\n")
+	if e := l.Build(Option{Folder: dir}); e == nil || !strings.Contains(e.Error(), "layout cycle: admin/admin -> base -> admin/admin") {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	writeFile(t, dir, "base_layout.html", "{{/* layout: missing */}}\n")
+	if e := l.Build(Option{Folder: dir}); e == nil || !strings.Contains(e.Error(), "base_layout.html: layout 'missing' is not found") {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
+
+func Test_BuildError(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "good.html", "good {{.}}")
+	writeFile(t, dir, "bad.html", "bad {{.")
+
+	var l Layout
+	e := l.Build(Option{Folder: dir, Watch: true, WatchInterval: 5 * time.Millisecond})
+	defer l.Close()
+	if e == nil || !strings.Contains(e.Error(), "bad.html") {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	// the pages which compile are served, and the broken one is retried
+	if s := render(&l, "good", "x"); s != "good x" {
+		t.Errorf("unexpected output '%s'", s)
+	}
+	if s := render(&l, "bad", "x"); s != "Not Found\n" {
+		t.Errorf("unexpected output '%s'", s)
+	}
+	writeFile(t, dir, "bad.html", "fixed {{.}}")
+	waitFor(t, &l, "bad", "fixed x")
+}
+
+func Test_Execute(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "index.html", `hello {{.Name}}`)
+	writeFile(t, dir, "error.html", `<p>{{.Status}} {{.Page}}</p>`)
+
+	var errs []error
+	var l Layout
+	if e := l.Build(Option{Folder: dir, OnError: func(e error) { errs = append(errs, e) }}); e != nil {
+		t.Fatal(e)
+	}
+
+	var sb strings.Builder
+	if e := l.Execute(&sb, "index", map[string]string{"Name": "world"}); e != nil || sb.String() != "hello world" {
+		t.Errorf("unexpected output '%s', %v", sb.String(), e)
+	}
+	sb.Reset()
+	if e := l.Execute(&sb, "index", 1); e == nil || sb.Len() > 0 {
+		t.Errorf("unexpected output '%s', %v", sb.String(), e)
+	}
+	if e := l.Execute(&sb, "missing", nil); !errors.Is(e, ErrNotFound) {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	w := httptest.NewRecorder()
+	l.RenderStatus(w, http.StatusForbidden, "index", map[string]string{"Name": "world"})
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("unexpected response %d, %v", w.Code, w.Header())
+	}
+
+	l.ErrorPage = "error"
+	for _, c := range []struct {
+		data   interface{}
+		name   string
+		status int
+	}{
+		{1, "index", http.StatusInternalServerError},
+		{nil, "missing", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		l.Render(w, c.name, c.data)
+		expect := fmt.Sprintf("<p>%d %s</p>", c.status, c.name)
+		if w.Code != c.status || w.Body.String() != expect {
+			t.Errorf("unexpected response %d '%s'", w.Code, w.Body.String())
+		}
+	}
+	if len(errs) != 1 {
+		t.Errorf("expect 1 error, got %v", errs)
+	}
+}
+
+func Test_FS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/main_layout.html":    {Data: []byte(`<main>{{template "body" .}}</main>`)},
+		"views/sub/index.html":      {Data: []byte("{{/* layout: main */}}\n{{define \"body\"}}{{template \"partials/name\" .}}{{end}}")},
+		"views/partials/name.html":  {Data: []byte(`embedded {{.}}`)},
+		"views/sub/ignored.txt":     {Data: []byte(`not a page`)},
+		"other/ignored_layout.html": {Data: []byte(`{{.`)},
+	}
+
+	var l Layout
+	if e := l.Build(Option{FS: fsys}); e != nil {
+		t.Fatal(e)
+	}
+	if s := render(&l, "sub/index", "x"); s != "<main>embedded x</main>" {
+		t.Errorf("unexpected output '%s'", s)
+	}
+
+	// the OS file system is used in debug mode
+	dir := t.TempDir()
+	writeFile(t, dir, "sub/index.html", "on disk {{.}}")
+	if e := l.Build(Option{FS: fsys, Folder: dir, Debug: true}); e != nil {
+		t.Fatal(e)
+	}
+	if s := render(&l, "sub/index", "x"); s != "on disk x" {
+		t.Errorf("unexpected output '%s'", s)
+	}
+
+	// pages are read on every request in debug mode, but layouts and
+	// partials are not pages
+	writeFile(t, dir, "main_layout.html", `<main>{{template "body" .}}</main>`)
+	writeFile(t, dir, "partials/name.html", `name {{.}}`)
+	writeFile(t, dir, "sub/index.html", "{{/* layout: main */}}\n{{define \"body\"}}{{template \"partials/name\" .}}{{end}}")
+	if s := render(&l, "sub/index", "x"); s != "<main>name x</main>" {
+		t.Errorf("unexpected output '%s'", s)
+	}
+	for _, name := range []string{"main_layout", "partials/name", "missing"} {
+		if s := render(&l, name, "x"); s != "Not Found\n" {
+			t.Errorf("%s: unexpected output '%s'", name, s)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package layout
+
+import (
+	"io/fs"
+	"strings"
+	"time"
+)
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// scan returns the states of the layout and page files, by their paths
+// relative to 'Folder'
+func (l *Layout) scan() map[string]fileStat {
+	stats := make(map[string]fileStat)
+	fs.WalkDir(l.fsys, ".", func(path string, d fs.DirEntry, e error) error {
+		if e != nil || d.IsDir() || !strings.HasSuffix(path, l.Ext) {
+			return nil
+		}
+		if fi, e := d.Info(); e == nil {
+			stats[path] = fileStat{modTime: fi.ModTime(), size: fi.Size()}
+		}
+		return nil
+	})
+	return stats
+}
+
+func (l *Layout) startWatch() {
+	interval := l.WatchInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	l.stop = make(chan struct{})
+	l.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer l.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				l.reload()
+			}
+		}
+	}(l.stop)
+}
+
+// Close stops watching the files
+func (l *Layout) Close() {
+	if l.stop != nil {
+		close(l.stop)
+		l.wg.Wait()
+		l.stop = nil
+	}
+}
+
+// reload recompiles the pages affected by the changed files, and replaces
+// the pages as a whole, so that the rendering is not blocked. If a page
+// fails to recompile, the old version is kept, and the page is retried on
+// the next change.
+func (l *Layout) reload() {
+	stats := l.scan()
+
+	var changed []string
+	for path, st := range stats {
+		if old, ok := l.stats[path]; !ok || old != st {
+			changed = append(changed, path)
+		}
+	}
+	for path := range l.stats {
+		if _, ok := stats[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	l.stats = stats
+
+	// copy the sources, so that they are not changed if a file fails
+	srcs := &sources{
+		layouts:  make(map[string]*source, len(l.sources.layouts)),
+		partials: make(map[string]string, len(l.sources.partials)),
+	}
+	for name, src := range l.sources.layouts {
+		srcs.layouts[name] = src
+	}
+	for name, text := range l.sources.partials {
+		srcs.partials[name] = text
+	}
+
+	all := false
+	dirty := make(map[string]string) // page name => path
+	for _, path := range changed {
+		kind, name := l.classify(path)
+		if kind == pageFile {
+			dirty[name] = path
+			continue
+		}
+
+		if _, ok := stats[path]; !ok {
+			delete(srcs.layouts, name)
+			delete(srcs.partials, name)
+		} else if src, e := l.readSource(path); e != nil {
+			l.reportError(e)
+			continue
+		} else if kind == layoutFile {
+			srcs.layouts[name] = src
+		} else {
+			srcs.partials[name] = src.text
+		}
+
+		if kind == partialFile {
+			all = true
+			continue
+		}
+		// recompile the pages which use the layout, directly or not
+		for pn, p := range l.deps {
+			for _, ln := range p.layouts {
+				if ln == name {
+					dirty[pn] = p.path
+					break
+				}
+			}
+		}
+	}
+	for pn, p := range l.deps {
+		if all || p.failed {
+			dirty[pn] = p.path
+		}
+	}
+	l.sources = srcs
+
+	old, _ := l.pages.Load().(tmplmap)
+	pages := make(tmplmap, len(old))
+	for name, t := range old {
+		pages[name] = t
+	}
+
+	for name, path := range dirty {
+		if _, ok := stats[path]; !ok {
+			delete(pages, name)
+			delete(l.deps, name)
+			continue
+		}
+
+		t, layouts, e := l.compile(path, srcs)
+		if e != nil {
+			l.reportError(e)
+			// the layouts of a failed page are unknown, record it so that
+			// it is retried when a layout or partial is fixed
+			l.deps[name] = &page{path: path, failed: true}
+			continue
+		}
+		pages[name] = t
+		l.deps[name] = &page{path: path, layouts: layouts}
+	}
+
+	l.pages.Store(pages)
+}
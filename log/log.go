@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 )
@@ -27,6 +28,25 @@ var strLevel = []string{
 	"FATAL",
 }
 
+func (l Level) String() string {
+	if int(l) < len(strLevel) {
+		return strLevel[l]
+	}
+	return fmt.Sprintf("LEVEL(%d)", l)
+}
+
+// ParseLevel converts a level name, as written in the log, to a Level,
+// the name is case insensitive
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToUpper(s)
+	for i, name := range strLevel {
+		if name == s {
+			return Level(i), nil
+		}
+	}
+	return Debug, fmt.Errorf("invalid log level '%s'", s)
+}
+
 type Logger struct {
 	ToStdErr       bool
 	ToFile         bool
@@ -0,0 +1,359 @@
+// Package logfile reads the log files written by log.Logger
+package logfile
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/localvar/go-utils/log"
+)
+
+const (
+	fileNameLayout = "20060102_1504.log"
+	timeLayout     = "15:04:05.000"
+)
+
+// Entry is a log entry read from a log file
+type Entry struct {
+	Time    time.Time
+	Level   log.Level
+	File    string // source file name, only available if written 'WithFile'
+	Line    int    // source line number, only available if written 'WithFile'
+	Message string // message without the trailing new line
+}
+
+// File is a log file, 'Start' is the beginning of its period
+type File struct {
+	Path  string
+	Start time.Time
+}
+
+// Query selects log entries from the files of a log.Logger, the
+// 'NoTime', 'NoLevel' and 'WithFile' fields must match the settings of
+// the logger which wrote the files
+type Query struct {
+	Folder         string
+	FileNamePrefix string
+	NoTime         bool
+	NoLevel        bool
+	WithFile       bool
+	From           time.Time // entries before 'From' are skipped, if not zero
+	To             time.Time // entries at or after 'To' are skipped, if not zero
+	MinLevel       log.Level
+	Contains       string        // only entries whose message contains it are selected
+	PollInterval   time.Duration // used by 'Follow', default is 500ms
+}
+
+// Files returns all log files of the query in chronological order
+func (q *Query) Files() ([]File, error) {
+	folder := q.Folder
+	if len(folder) == 0 {
+		folder = "."
+	}
+
+	pattern := filepath.Join(folder, q.FileNamePrefix+"*.log")
+	paths, e := filepath.Glob(pattern)
+	if e != nil {
+		return nil, e
+	}
+
+	files := make([]File, 0, len(paths))
+	for _, path := range paths {
+		name := filepath.Base(path)[len(q.FileNamePrefix):]
+		t, e := time.ParseInLocation(fileNameLayout, name, time.Local)
+		if e != nil {
+			continue
+		}
+		files = append(files, File{Path: path, Start: t})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Start.Before(files[j].Start)
+	})
+	return files, nil
+}
+
+// match reports whether the entry is selected by the query
+func (q *Query) match(en *Entry) bool {
+	if en.Level < q.MinLevel {
+		return false
+	}
+	if !q.From.IsZero() && en.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !en.Time.Before(q.To) {
+		return false
+	}
+	return len(q.Contains) == 0 || strings.Contains(en.Message, q.Contains)
+}
+
+// inRange reports whether the i-th file may contain entries in the
+// time range of the query
+func (q *Query) inRange(files []File, i int) bool {
+	if !q.To.IsZero() && !files[i].Start.Before(q.To) {
+		return false
+	}
+	if !q.From.IsZero() && i+1 < len(files) && !files[i+1].Start.After(q.From) {
+		return false
+	}
+	return true
+}
+
+// Run calls 'fn' for every selected entry in chronological order, it
+// stops and returns the error if 'fn' returns an error
+func (q *Query) Run(fn func(en *Entry) error) error {
+	files, e := q.Files()
+	if e != nil {
+		return e
+	}
+
+	for i := range files {
+		if !q.inRange(files, i) {
+			continue
+		}
+		if e = q.readFile(&files[i], fn); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (q *Query) readFile(f *File, fn func(en *Entry) error) error {
+	file, e := os.Open(f.Path)
+	if e != nil {
+		return e
+	}
+	defer file.Close()
+
+	p := q.newParser(f, fn)
+	if _, e = p.feed(file); e != nil {
+		return e
+	}
+	return p.flush()
+}
+
+// Follow works like 'tail -f': it first calls 'fn' for the selected
+// entries of the last file, then waits for new entries, including those
+// in files created by rotation, until 'stop' is closed or 'fn' returns an
+// error
+func (q *Query) Follow(stop <-chan struct{}, fn func(en *Entry) error) error {
+	interval := q.PollInterval
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+
+	var (
+		cur    File
+		file   *os.File
+		p      *parser
+		offset int64
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		files, e := q.Files()
+		if e != nil {
+			return e
+		}
+
+		// read everything available in the current file, and move to the
+		// next one if the file was rotated
+		for {
+			if file == nil {
+				if len(files) == 0 {
+					break
+				}
+				cur = files[len(files)-1]
+				if file, e = os.Open(cur.Path); e != nil {
+					return e
+				}
+				p, offset = q.newParser(&cur, fn), 0
+			}
+
+			n, e := p.feed(io.NewSectionReader(file, offset, 1<<62))
+			offset += n
+			if e != nil {
+				return e
+			}
+
+			next := nextFile(files, cur)
+			if next == nil {
+				break
+			}
+			if e = p.flush(); e != nil {
+				return e
+			}
+			file.Close()
+			cur = *next
+			if file, e = os.Open(cur.Path); e != nil {
+				return e
+			}
+			p, offset = q.newParser(&cur, fn), 0
+		}
+
+		// entries are written to file as a whole, so the pending one is
+		// complete if there is no more data
+		if p != nil {
+			if e := p.flush(); e != nil {
+				return e
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func nextFile(files []File, cur File) *File {
+	for i := range files {
+		if files[i].Start.After(cur.Start) {
+			return &files[i]
+		}
+	}
+	return nil
+}
+
+type parser struct {
+	q       *Query
+	file    *File
+	fn      func(en *Entry) error
+	last    time.Time
+	pending *Entry
+}
+
+func (q *Query) newParser(f *File, fn func(en *Entry) error) *parser {
+	return &parser{q: q, file: f, fn: fn, last: f.Start}
+}
+
+// feed parses all complete lines in 'r', and returns the number of bytes
+// consumed
+func (p *parser) feed(r io.Reader) (int64, error) {
+	var n int64
+	br := bufio.NewReader(r)
+	for {
+		line, e := br.ReadBytes('\n')
+		if e == io.EOF {
+			// an incomplete line, leave it to the next feed
+			return n, nil
+		} else if e != nil {
+			return n, e
+		}
+		n += int64(len(line))
+		if e = p.parseLine(bytes.TrimRight(line, "\r\n")); e != nil {
+			return n, e
+		}
+	}
+}
+
+// flush emits the pending entry
+func (p *parser) flush() error {
+	en := p.pending
+	p.pending = nil
+	if en == nil || !p.q.match(en) {
+		return nil
+	}
+	return p.fn(en)
+}
+
+func (p *parser) parseLine(line []byte) error {
+	en, ok := p.parseEntry(string(line))
+	if !ok {
+		// continuation of a multi-line message
+		if p.pending != nil {
+			p.pending.Message += "\n" + string(line)
+		}
+		return nil
+	}
+
+	if e := p.flush(); e != nil {
+		return e
+	}
+	p.pending = en
+	return nil
+}
+
+func (p *parser) parseEntry(line string) (*Entry, bool) {
+	en := &Entry{Time: p.last}
+
+	if !p.q.NoTime {
+		if len(line) <= len(timeLayout) || line[len(timeLayout)] != '\t' {
+			return nil, false
+		}
+		t, ok := p.parseTime(line[:len(timeLayout)])
+		if !ok {
+			return nil, false
+		}
+		en.Time = t
+		line = line[len(timeLayout)+1:]
+	}
+
+	if !p.q.NoLevel {
+		i := strings.IndexByte(line, '\t')
+		if i < 0 {
+			return nil, false
+		}
+		lvl, e := log.ParseLevel(line[:i])
+		if e != nil || line[:i] != lvl.String() {
+			return nil, false
+		}
+		en.Level = lvl
+		line = line[i+1:]
+	}
+
+	if p.q.WithFile {
+		i := strings.IndexByte(line, '\t')
+		if i < 1 || line[i-1] != ')' {
+			return nil, false
+		}
+		j := strings.LastIndexByte(line[:i], '(')
+		if j < 0 {
+			return nil, false
+		}
+		n, e := strconv.Atoi(line[j+1 : i-1])
+		if e != nil {
+			return nil, false
+		}
+		en.File, en.Line = line[:j], n
+		line = line[i+1:]
+	}
+
+	en.Message = line
+	p.last = en.Time
+	return en, true
+}
+
+// parseTime combines the time of day in the log with the date of the
+// file, the period of a file may cross midnight
+func (p *parser) parseTime(s string) (time.Time, bool) {
+	tod, e := time.Parse(timeLayout, s)
+	if e != nil {
+		return time.Time{}, false
+	}
+
+	start := p.file.Start
+	y, m, d := start.Date()
+	h, mi, sec := tod.Clock()
+	t := time.Date(y, m, d, h, mi, sec, tod.Nanosecond(), start.Location())
+	if t.Before(start) {
+		t = t.AddDate(0, 0, 1)
+	}
+	for t.Before(p.last) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true
+}
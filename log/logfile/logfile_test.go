@@ -0,0 +1,153 @@
+package logfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/localvar/go-utils/log"
+)
+
+func writeFile(t *testing.T, folder, name, content string) {
+	path := filepath.Join(folder, name)
+	if e := ioutil.WriteFile(path, []byte(content), 0666); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func Test_Run(t *testing.T) {
+	folder, e := ioutil.TempDir("", "logfile")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(folder)
+
+	writeFile(t, folder, "app_20200101_1800.log",
+		"18:00:01.000\tINFO\ta.go(10)\tstarted\n"+
+			"23:59:59.000\tERROR\ta.go(20)\tfirst line\nsecond line\n"+
+			"00:00:01.500\tWARNING\tb.go(30)\tafter midnight\n")
+	writeFile(t, folder, "app_20200102_1800.log",
+		"18:30:00.000\tERROR\tc.go(40)\tnext file\n")
+	writeFile(t, folder, "other_20200102_1800.log",
+		"18:30:00.000\tERROR\tc.go(40)\tother logger\n")
+
+	q := Query{Folder: folder, FileNamePrefix: "app_", WithFile: true}
+
+	var entries []*Entry
+	collect := func(en *Entry) error {
+		entries = append(entries, en)
+		return nil
+	}
+
+	if e = q.Run(collect); e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expect 4 entries, got %d", len(entries))
+	}
+	if entries[1].Message != "first line\nsecond line" {
+		t.Errorf("multi-line message is not parsed: %q", entries[1].Message)
+	}
+	expect := time.Date(2020, 1, 2, 0, 0, 1, 5e8, time.Local)
+	if !entries[2].Time.Equal(expect) {
+		t.Errorf("expect time %v, got %v", expect, entries[2].Time)
+	}
+	if entries[3].File != "c.go" || entries[3].Line != 40 {
+		t.Errorf("source file is not parsed: %s(%d)", entries[3].File, entries[3].Line)
+	}
+
+	entries = nil
+	q.MinLevel = log.Error
+	q.From = time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)
+	if e = q.Run(collect); e != nil {
+		t.Fatal(e)
+	}
+	if len(entries) != 1 || entries[0].Message != "next file" {
+		t.Errorf("filter does not work")
+	}
+}
+
+func appendFile(t *testing.T, folder, name, content string) {
+	f, e := os.OpenFile(filepath.Join(folder, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	if _, e = f.WriteString(content); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func Test_Follow(t *testing.T) {
+	folder, e := ioutil.TempDir("", "logfile")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(folder)
+
+	writeFile(t, folder, "app_20200101_1700.log", "17:00:00.000\tINFO\told\n")
+	writeFile(t, folder, "app_20200101_1800.log",
+		"18:00:01.000\tINFO\tm0\n18:00:02.000\tINFO\tm1\n")
+
+	q := Query{Folder: folder, FileNamePrefix: "app_", PollInterval: 10 * time.Millisecond}
+	msgs := make(chan string, 100)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Follow(stop, func(en *Entry) error {
+			msgs <- en.Message
+			return nil
+		})
+	}()
+
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case m := <-msgs:
+				if m != w {
+					t.Fatalf("expect message %q, got %q", w, m)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting for message %q", w)
+			}
+		}
+	}
+
+	// only the last file is read
+	expect("m0", "m1")
+
+	// append to the file, the incomplete line is not parsed until it is
+	// completed
+	appendFile(t, folder, "app_20200101_1800.log", "18:00:03.000\tINFO\tm2\n18:00:04.000\tINFO\tm")
+	expect("m2")
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, folder, "app_20200101_1800.log", "3\n")
+	expect("m3")
+
+	// rotate the file, entries written to the old file before the rotation
+	// are not lost
+	appendFile(t, folder, "app_20200101_1800.log", "18:59:59.000\tINFO\tm4\n")
+	writeFile(t, folder, "app_20200101_1900.log",
+		"19:00:00.000\tINFO\tm5\n19:00:01.000\tINFO\tm6\n")
+	expect("m4", "m5", "m6")
+	appendFile(t, folder, "app_20200101_1900.log", "19:00:02.000\tINFO\tm7\n")
+	expect("m7")
+
+	close(stop)
+	select {
+	case e := <-done:
+		if e != nil {
+			t.Fatal(e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Follow does not return after stop is closed")
+	}
+
+	// no entry is duplicated
+	if len(msgs) != 0 {
+		t.Errorf("unexpected message %q", <-msgs)
+	}
+}
@@ -0,0 +1,101 @@
+// Command logq queries the log files written by log.Logger
+//
+// Usage:
+//
+//	logq -dir logs -prefix app_ -from "2020-01-02 15:00" -level warning -grep timeout
+//	logq -dir logs -prefix app_ -f
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/localvar/go-utils/log"
+	"github.com/localvar/go-utils/log/logfile"
+)
+
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, e := time.ParseInLocation(layout, s, time.Local); e == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s'", s)
+}
+
+func main() {
+	var (
+		q                  logfile.Query
+		from, to, minLevel string
+		follow             bool
+	)
+
+	flag.StringVar(&q.Folder, "dir", ".", "folder of the log files")
+	flag.StringVar(&q.FileNamePrefix, "prefix", "", "file name prefix of the log files")
+	flag.BoolVar(&q.NoTime, "notime", false, "log files are written without time")
+	flag.BoolVar(&q.NoLevel, "nolevel", false, "log files are written without level")
+	flag.BoolVar(&q.WithFile, "withfile", false, "log files are written with source file")
+	flag.StringVar(&from, "from", "", "select entries at or after this time, e.g. '2006-01-02 15:04:05'")
+	flag.StringVar(&to, "to", "", "select entries before this time")
+	flag.StringVar(&minLevel, "level", "debug", "minimal level of selected entries")
+	flag.StringVar(&q.Contains, "grep", "", "select entries whose message contains this string")
+	flag.BoolVar(&follow, "f", false, "follow new entries, like 'tail -f'")
+	flag.Parse()
+
+	var e error
+	if q.From, e = parseTime(from); e != nil {
+		fatal(e)
+	}
+	if q.To, e = parseTime(to); e != nil {
+		fatal(e)
+	}
+	if q.MinLevel, e = log.ParseLevel(minLevel); e != nil {
+		fatal(e)
+	}
+
+	write := func(en *logfile.Entry) error {
+		s := en.Time.Format("2006-01-02 15:04:05.000")
+		if !q.NoLevel {
+			s += "\t" + en.Level.String()
+		}
+		if q.WithFile {
+			s += fmt.Sprintf("\t%s(%d)", en.File, en.Line)
+		}
+		_, e := fmt.Println(s + "\t" + en.Message)
+		return e
+	}
+
+	if !follow {
+		e = q.Run(write)
+	} else {
+		stop := make(chan struct{})
+		go func() {
+			ch := make(chan os.Signal, 1)
+			signal.Notify(ch, os.Interrupt)
+			<-ch
+			close(stop)
+		}()
+		e = q.Follow(stop, write)
+	}
+
+	if e != nil {
+		fatal(e)
+	}
+}
+
+func fatal(e error) {
+	fmt.Fprintln(os.Stderr, e)
+	os.Exit(1)
+}
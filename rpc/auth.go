@@ -0,0 +1,356 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Principal is the authenticated caller of a call
+type Principal struct {
+	ID      string                 // e.g. the user ID or the key ID
+	Scheme  string                 // 'apikey', 'hmac', 'jwt' or a custom one
+	Scopes  []string               // permissions of the caller
+	Claims  map[string]interface{} // claims of a JWT, nil for other schemes
+	Expires time.Time              // zero if the credentials never expire
+}
+
+// HasScope reports whether the principal has 'scope'
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNoCredentials is returned by an Authenticator if the request does not
+// carry its kind of credentials, so that the next one is tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator authenticates the caller of a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+// Authenticate implements Authenticator
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// WithAuth requires the calls to a route to be authenticated by one of
+// 'authenticators', they are tried in order
+func WithAuth(authenticators ...Authenticator) RouteOption {
+	return func(r *route) {
+		r.authenticators = append(r.authenticators, authenticators...)
+	}
+}
+
+// WithScopes requires the principal of the calls to a route to have all
+// of 'scopes', it should be used with WithAuth
+func WithScopes(scopes ...string) RouteOption {
+	return func(r *route) {
+		r.scopes = append(r.scopes, scopes...)
+	}
+}
+
+type principalKey struct{}
+
+// authenticated is carried by the context of an authenticated call
+type authenticated struct {
+	principal *Principal
+	by        Authenticator // the authenticator which accepted the call
+}
+
+// PrincipalFromContext returns the authenticated caller of a call, or nil
+// if the route does not require authentication
+func PrincipalFromContext(ctx context.Context) *Principal {
+	if a, _ := ctx.Value(principalKey{}).(*authenticated); a != nil {
+		return a.principal
+	}
+	return nil
+}
+
+// KeyByPrincipal uses the ID of the principal as the key of rate limits,
+// and falls back to KeyByIP if the call is not authenticated
+func KeyByPrincipal(r *http.Request) string {
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		return p.Scheme + ":" + p.ID
+	}
+	return KeyByIP(r)
+}
+
+// authenticate authenticates 'r' with the authenticators of 'route', and
+// returns a copy of 'r' whose context carries the principal
+func authenticate(route *route, r *http.Request) (*http.Request, error) {
+	if len(route.authenticators) == 0 {
+		return r, nil
+	}
+
+	var p *Principal
+	var by Authenticator
+	var failure error
+	for _, a := range route.authenticators {
+		v, e := a.Authenticate(r)
+		if e == nil && v != nil {
+			p, by = v, a
+			break
+		}
+		if e != nil && e != ErrNoCredentials && failure == nil {
+			failure = e
+		}
+	}
+
+	if p == nil {
+		if errors.Is(failure, errBodyTooLarge) {
+			return nil, errBodyTooLarge
+		}
+		msg := "missing credentials"
+		if failure != nil {
+			msg = failure.Error()
+		}
+		return nil, NewError(CodeUnauthenticated, http.StatusUnauthorized, msg)
+	}
+
+	for _, scope := range route.scopes {
+		if !p.HasScope(scope) {
+			msg := "scope '" + scope + "' is required"
+			return nil, NewError(CodePermissionDenied, http.StatusForbidden, msg)
+		}
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, &authenticated{principal: p, by: by})), nil
+}
+
+// APIKeyAuth authenticates requests with static API keys
+type APIKeyAuth struct {
+	Header string                // header of the key, default is 'X-Api-Key'
+	Keys   map[string]*Principal // principals of the keys
+}
+
+// Authenticate implements Authenticator
+func (a *APIKeyAuth) Authenticate(r *http.Request) (*Principal, error) {
+	header := a.Header
+	if len(header) == 0 {
+		header = "X-Api-Key"
+	}
+
+	key := r.Header.Get(header)
+	if len(key) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	p, ok := a.Keys[key]
+	if !ok {
+		return nil, errors.New("invalid api key")
+	}
+	if len(p.Scheme) == 0 {
+		c := *p
+		c.Scheme = "apikey"
+		p = &c
+	}
+	return p, nil
+}
+
+// Headers of HMAC signed requests
+const (
+	HMACKeyHeader       = "X-Rpc-Key"
+	HMACTimestampHeader = "X-Rpc-Timestamp"
+	HMACNonceHeader     = "X-Rpc-Nonce"
+	HMACSignatureHeader = "X-Rpc-Signature"
+)
+
+// hmacSignature signs a request, the string to sign is
+//
+//	METHOD\nREQUEST_URI\nTIMESTAMP\nNONCE\nhex(sha256(BODY))
+func hmacSignature(secret, method, uri, timestamp, nonce string, body []byte) string {
+	h := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, method+"\n"+uri+"\n"+timestamp+"\n"+nonce+"\n")
+	io.WriteString(mac, hex.EncodeToString(h[:]))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HMACKey is a key of HMACAuth
+type HMACKey struct {
+	Secret string
+	Scopes []string
+}
+
+// NonceStore records the nonces of signed requests to reject replays,
+// implement it with a shared storage for a cluster of servers
+type NonceStore interface {
+	// Add records 'nonce' until 'expires', it returns false if the nonce
+	// is already recorded
+	Add(nonce string, expires time.Time) bool
+}
+
+// MemoryNonceStore is a NonceStore in memory
+type MemoryNonceStore struct {
+	lock      sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// Add implements NonceStore
+func (m *MemoryNonceStore) Add(nonce string, expires time.Time) bool {
+	now := time.Now()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.nonces == nil {
+		m.nonces = make(map[string]time.Time)
+	}
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, v := range m.nonces {
+			if now.After(v) {
+				delete(m.nonces, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	if v, ok := m.nonces[nonce]; ok && now.Before(v) {
+		return false
+	}
+	m.nonces[nonce] = expires
+	return true
+}
+
+// HMACAuth authenticates requests signed with HMAC-SHA256, the signature
+// covers the method, the URI, a timestamp, a nonce and the body, see
+// HMACTransport for the client side. It is not suitable for WebSocket
+// connections, as the handshake is authenticated on every call, and the
+// nonce is rejected as a replay. The entries of a batch cannot be verified
+// one by one for the same reason, so the batch route must be registered
+// with the HMACAuth, and its entries get the principal of the batch.
+type HMACAuth struct {
+	Keys    map[string]HMACKey // keys by the key ID
+	MaxSkew time.Duration      // maximal clock skew, default is 5 minutes
+	Nonces  NonceStore         // default is a MemoryNonceStore
+
+	once sync.Once
+}
+
+// Authenticate implements Authenticator, the body of 'r' is read and then
+// replaced, so that it can be read again
+func (a *HMACAuth) Authenticate(r *http.Request) (*Principal, error) {
+	keyID := r.Header.Get(HMACKeyHeader)
+	if len(keyID) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	// the body and the nonce of a batch belong to the batch route, which
+	// has authenticated the request if it uses this HMACAuth
+	if r.Context().Value(batchKey{}) != nil {
+		if v, _ := r.Context().Value(principalKey{}).(*authenticated); v != nil && v.by == Authenticator(a) {
+			return v.principal, nil
+		}
+		return nil, errors.New("HMAC signed routes must be authenticated by the batch route, see AddBatch")
+	}
+
+	key, ok := a.Keys[keyID]
+	if !ok {
+		return nil, errors.New("invalid key")
+	}
+
+	skew := a.MaxSkew
+	if skew <= 0 {
+		skew = 5 * time.Minute
+	}
+
+	ts := r.Header.Get(HMACTimestampHeader)
+	sec, e := strconv.ParseInt(ts, 10, 64)
+	if e != nil {
+		return nil, errors.New("invalid timestamp")
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > skew || d < -skew {
+		return nil, errors.New("timestamp is out of range")
+	}
+
+	nonce := r.Header.Get(HMACNonceHeader)
+	if len(nonce) == 0 {
+		return nil, errors.New("missing nonce")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, e = ioutil.ReadAll(r.Body); e != nil {
+			return nil, e
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	expect := hmacSignature(key.Secret, r.Method, r.URL.RequestURI(), ts, nonce, body)
+	sig := r.Header.Get(HMACSignatureHeader)
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(expect)) != 1 {
+		return nil, errors.New("invalid signature")
+	}
+
+	// check the nonce after the signature, so that forged requests cannot
+	// consume nonces
+	a.once.Do(func() {
+		if a.Nonces == nil {
+			a.Nonces = &MemoryNonceStore{}
+		}
+	})
+	if !a.Nonces.Add(keyID+":"+nonce, time.Now().Add(2*skew)) {
+		return nil, errors.New("replayed request")
+	}
+
+	return &Principal{ID: keyID, Scheme: "hmac", Scopes: key.Scopes}, nil
+}
+
+// HMACTransport is an http.RoundTripper which signs requests for HMACAuth,
+// set it as the transport of the HTTP client of a Client
+type HMACTransport struct {
+	KeyID  string
+	Secret string
+	Base   http.RoundTripper // default is http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *HMACTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		var e error
+		if body, e = ioutil.ReadAll(r.Body); e != nil {
+			return nil, e
+		}
+		r.Body.Close()
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := randomKey()
+
+	// a RoundTripper must not modify the request
+	r = r.Clone(r.Context())
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.Header.Set(HMACKeyHeader, t.KeyID)
+	r.Header.Set(HMACTimestampHeader, ts)
+	r.Header.Set(HMACNonceHeader, nonce)
+	r.Header.Set(HMACSignatureHeader, hmacSignature(t.Secret, r.Method, r.URL.RequestURI(), ts, nonce, body))
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
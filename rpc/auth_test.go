@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func whoami(ctx context.Context) (string, error) {
+	p := PrincipalFromContext(ctx)
+	return p.Scheme + ":" + p.ID, nil
+}
+
+func Test_Auth(t *testing.T) {
+	secret := []byte("jwt secret")
+	apiKey := &APIKeyAuth{Keys: map[string]*Principal{"key1": {ID: "svc"}}}
+	signed := &HMACAuth{Keys: map[string]HMACKey{"k1": {Secret: "hmac secret"}}}
+	jwt := &JWTAuth{Secret: secret, Issuer: "test"}
+
+	s := NewServer("/")
+	s.Add("", "whoami", whoami, WithAuth(apiKey, signed, jwt))
+	s.Add("", "admin", whoami, WithAuth(jwt), WithScopes("admin"))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	call := func(c *Client, route string) (string, error) {
+		var res string
+		e := c.Call(context.Background(), route, nil, &res)
+		return res, e
+	}
+	code := func(e error) string {
+		var re *Error
+		if errors.As(e, &re) {
+			return re.Code
+		}
+		return ""
+	}
+
+	c := NewClient(ts.URL + "/")
+	if _, e := call(c, "whoami"); code(e) != CodeUnauthenticated {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	c.Header = http.Header{"X-Api-Key": {"key1"}}
+	if res, e := call(c, "whoami"); e != nil || res != "apikey:svc" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+	c.Header = http.Header{"X-Api-Key": {"bad"}}
+	if _, e := call(c, "whoami"); code(e) != CodeUnauthenticated {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	c = NewClient(ts.URL + "/")
+	c.HTTPClient = &http.Client{Transport: &HMACTransport{KeyID: "k1", Secret: "hmac secret"}}
+	if res, e := call(c, "whoami"); e != nil || res != "hmac:k1" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+	c.HTTPClient.Transport = &HMACTransport{KeyID: "k1", Secret: "wrong"}
+	if _, e := call(c, "whoami"); code(e) != CodeUnauthenticated {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	bearer := func(claims map[string]interface{}) http.Header {
+		token, _ := SignJWT(claims, secret)
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	c = NewClient(ts.URL + "/")
+	c.Header = bearer(map[string]interface{}{"sub": "alice", "iss": "test", "exp": exp})
+	if res, e := call(c, "whoami"); e != nil || res != "jwt:alice" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+	if _, e := call(c, "admin"); code(e) != CodePermissionDenied {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	c.Header = bearer(map[string]interface{}{"sub": "bob", "iss": "test", "exp": exp, "scope": "read admin"})
+	if res, e := call(c, "admin"); e != nil || res != "jwt:bob" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+
+	c.Header = bearer(map[string]interface{}{"sub": "bob", "iss": "test", "exp": time.Now().Add(-time.Hour).Unix()})
+	if _, e := call(c, "whoami"); code(e) != CodeUnauthenticated {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
+
+func Test_HMACReplay(t *testing.T) {
+	a := &HMACAuth{Keys: map[string]HMACKey{"k1": {Secret: "s"}}}
+	var signed *http.Request
+	tr := &HMACTransport{KeyID: "k1", Secret: "s", Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		signed = r
+		return nil, errors.New("not sent")
+	})}
+	tr.RoundTrip(httptest.NewRequest("POST", "/x?a=1", nil))
+
+	if _, e := a.Authenticate(signed); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := a.Authenticate(signed); e == nil {
+		t.Error("replayed request is accepted")
+	}
+}
+
+func Test_HMACBatch(t *testing.T) {
+	signed := &HMACAuth{Keys: map[string]HMACKey{"k1": {Secret: "s"}}}
+
+	s := NewServer("/")
+	s.Add("", "whoami", whoami, WithAuth(signed))
+	s.AddBatch("batch", 2, WithAuth(signed))
+	s.AddBatch("open", 2)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	c.HTTPClient = &http.Client{Transport: &HMACTransport{KeyID: "k1", Secret: "s"}}
+
+	// the entries get the principal of the batch
+	var res1, res2 string
+	b := c.NewBatch("batch")
+	c1, c2 := b.Add("whoami", nil, &res1), b.Add("whoami", nil, &res2)
+	if e := b.Send(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if c1.Err != nil || c2.Err != nil || res1 != "hmac:k1" || res2 != "hmac:k1" {
+		t.Errorf("unexpected results '%s' %v, '%s' %v", res1, c1.Err, res2, c2.Err)
+	}
+
+	b = c.NewBatch("open")
+	bc := b.Add("whoami", nil, nil)
+	if e := b.Send(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if bc.Err == nil || !strings.Contains(bc.Err.Error(), "authenticated by the batch route") {
+		t.Errorf("unexpected error: %v", bc.Err)
+	}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
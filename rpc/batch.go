@@ -0,0 +1,167 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// BatchEntry is a call in a batch request
+type BatchEntry struct {
+	Route string          `json:"route"`
+	Arg   json.RawMessage `json:"arg,omitempty"`
+}
+
+// AddBatch registers a batch route named 'name', its argument is an array
+// of BatchEntry, and its data is an array of Result in the same order.
+// At most 'concurrency' entries are run concurrently, entries are run one
+// by one if it is less than 2. All entries share the HTTP request of the
+// batch, and the method restriction of their routes is not checked.
+// The batch request must be encoded in JSON, but the response can be
+// encoded with any codec accepted by the client. Entries of routes using
+// HMACAuth require the batch route to use it too, e.g. with WithAuth in
+// 'opts'.
+func (s *Server) AddBatch(name string, concurrency int, opts ...RouteOption) {
+	s.Add("POST", name, func(r *http.Request, entries *[]BatchEntry) ([]*Result, error) {
+		return s.runBatch(name, r, *entries, concurrency), nil
+	}, opts...)
+}
+
+// AddBatch registers a batch route to DefaultServer
+func AddBatch(name string, concurrency int, opts ...RouteOption) {
+	DefaultServer.AddBatch(name, concurrency, opts...)
+}
+
+// batchKey marks the context of the entries of a batch
+type batchKey struct{}
+
+func (s *Server) runBatch(batch string, r *http.Request, entries []BatchEntry, concurrency int) []*Result {
+	results := make([]*Result, len(entries))
+
+	// the stored response of an idempotent batch is only looked up for the
+	// batch itself, not for its entries
+	ctx := context.WithValue(r.Context(), cachedCallKey{}, (*cachedCall)(nil))
+	r = r.WithContext(context.WithValue(ctx, batchKey{}, true))
+
+	run := func(i int) {
+		en := &entries[i]
+		route, ok := s.routes[en.Route]
+		if !ok || en.Route == batch {
+			msg := fmt.Sprintf("route '%s' not found", en.Route)
+			results[i], _ = newResult(nil, NewError(CodeNotFound, http.StatusNotFound, msg))
+			return
+		}
+		if route.stream {
+			msg := fmt.Sprintf("streaming route '%s' cannot be batched", en.Route)
+			results[i], _ = newResult(nil, NewError(CodeInvalidArgument, http.StatusBadRequest, msg))
+			return
+		}
+
+		res, e := s.call(en.Route, route, r, nil, func(arg interface{}) error {
+			if len(en.Arg) == 0 {
+				return nil
+			}
+			return s.unmarshalJSON(en.Arg, arg)
+		})
+		results[i], _ = newResult(res, e)
+	}
+
+	if concurrency < 2 {
+		for i := range entries {
+			run(i)
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i := range entries {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			run(i)
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// BatchCall is a call queued in a Batch, 'Err' is set after the batch is
+// sent, the 'Status' of an *Error in it is always 0, as the HTTP status of
+// the calls are not available
+type BatchCall struct {
+	Route  string
+	Arg    interface{}
+	Result interface{}
+	Err    error
+}
+
+// Batch queues calls and sends them together in a single request to a
+// batch route, see Server.AddBatch
+type Batch struct {
+	client *Client
+	route  string
+	calls  []*BatchCall
+}
+
+// NewBatch creates a new batch which sends calls to the batch route 'route'
+func (c *Client) NewBatch(route string) *Batch {
+	return &Batch{client: c, route: route}
+}
+
+// Add queues a call, the result is decoded into 'result' after the batch
+// is sent
+func (b *Batch) Add(route string, arg, result interface{}) *BatchCall {
+	bc := &BatchCall{Route: route, Arg: arg, Result: result}
+	b.calls = append(b.calls, bc)
+	return bc
+}
+
+// Send sends all queued calls, the returned error is the error of the
+// batch request, errors of the calls are stored in their 'Err' field.
+// The queue is cleared after Send.
+func (b *Batch) Send(ctx context.Context) error {
+	calls := b.calls
+	b.calls = nil
+	if len(calls) == 0 {
+		return nil
+	}
+
+	entries := make([]BatchEntry, len(calls))
+	for i, bc := range calls {
+		entries[i].Route = bc.Route
+		if bc.Arg == nil {
+			continue
+		}
+		data, e := json.Marshal(bc.Arg)
+		if e != nil {
+			return e
+		}
+		entries[i].Arg = data
+	}
+
+	var results []rawResult
+	if e := b.client.Call(ctx, b.route, entries, &results); e != nil {
+		for _, bc := range calls {
+			bc.Err = e
+		}
+		return e
+	}
+
+	for i, bc := range calls {
+		if i >= len(results) {
+			bc.Err = NewError(CodeInternal, 0, "no result in batch response")
+			continue
+		}
+		bc.Err = results[i].decode(bc.Result, 0)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Batch(t *testing.T) {
+	s := newTestServer("/")
+	s.AddBatch("batch", 4)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	b := NewClient(ts.URL + "/").NewBatch("batch")
+	var r1, r2 string
+	c1 := b.Add("echo", &echoArg{Text: "a"}, &r1)
+	c2 := b.Add("echo", &echoArg{Text: "b"}, &r2)
+	c3 := b.Add("echo", &echoArg{}, nil)
+	c4 := b.Add("missing", nil, nil)
+	c5 := b.Add("ping", nil, nil)
+
+	if e := b.Send(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+
+	if c1.Err != nil || c2.Err != nil || r1 != "a" || r2 != "b" {
+		t.Errorf("unexpected results: '%s' %v, '%s' %v", r1, c1.Err, r2, c2.Err)
+	}
+	if c3.Err == nil || c3.Err.Error() != "text is empty" {
+		t.Errorf("unexpected error: %v", c3.Err)
+	}
+	var re *Error
+	if !errors.As(c4.Err, &re) || re.Code != CodeNotFound {
+		t.Errorf("unexpected error: %v", c4.Err)
+	}
+	if c5.Err != nil {
+		t.Error(c5.Err)
+	}
+}
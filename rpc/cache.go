@@ -0,0 +1,317 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotencyHeader is the HTTP header which carries the idempotency key of
+// a call, retries of the call with the same key get the result of the first
+// attempt instead of running the handler again
+const IdempotencyHeader = "Idempotency-Key"
+
+// idempotencyLockTTL is how long a call with an idempotency key is locked,
+// retries during this period are rejected with CodeConflict. It prevents a
+// key from being locked forever if the server crashes during the call
+const idempotencyLockTTL = time.Minute
+
+// Idempotency configures the handling of IdempotencyHeader, it only applies
+// to requests other than GET and HEAD. It is disabled by default, as every
+// new key keeps a response in the ResponseStore, set 'Server.Idempotency'
+// to enable it, with authentication or rate limits against abuse.
+type Idempotency struct {
+	TTL time.Duration // how long a result is kept, default is 24 hours
+	Key KeyFunc       // scope of the keys, default is KeyByPrincipal
+}
+
+// StoredResponse is an encoded response in a ResponseStore, a response with
+// status 0 is a placeholder of a call in progress
+type StoredResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	ETag        string `json:"etag,omitempty"`
+	Body        []byte `json:"body"`
+}
+
+// ResponseStore stores the responses of idempotent calls and cached routes,
+// implement it with a shared storage to share them in a cluster of servers
+type ResponseStore interface {
+	// Get returns the response of 'key', or nil if it does not exist
+	Get(key string) (*StoredResponse, error)
+	// Add stores 'resp' as 'key' if the key does not exist, it returns
+	// false if the key exists
+	Add(key string, resp *StoredResponse, ttl time.Duration) (bool, error)
+	// Set stores 'resp' as 'key', it replaces the existing one
+	Set(key string, resp *StoredResponse, ttl time.Duration) error
+	// Delete removes 'key'
+	Delete(key string) error
+}
+
+type storedItem struct {
+	resp    *StoredResponse
+	expires time.Time
+}
+
+// MemoryResponseStore is a ResponseStore in memory, expired responses are
+// removed periodically
+type MemoryResponseStore struct {
+	lock      sync.Mutex
+	items     map[string]storedItem
+	lastSweep time.Time
+}
+
+// NewMemoryResponseStore creates a new MemoryResponseStore
+func NewMemoryResponseStore() *MemoryResponseStore {
+	return &MemoryResponseStore{items: make(map[string]storedItem), lastSweep: time.Now()}
+}
+
+// get returns the response of 'key', 'm.lock' must be held
+func (m *MemoryResponseStore) get(key string, now time.Time) *StoredResponse {
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, item := range m.items {
+			if now.After(item.expires) {
+				delete(m.items, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	item, ok := m.items[key]
+	if !ok || now.After(item.expires) {
+		return nil
+	}
+	return item.resp
+}
+
+// Get implements ResponseStore
+func (m *MemoryResponseStore) Get(key string) (*StoredResponse, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.get(key, time.Now()), nil
+}
+
+// Add implements ResponseStore
+func (m *MemoryResponseStore) Add(key string, resp *StoredResponse, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.get(key, now) != nil {
+		return false, nil
+	}
+	m.items[key] = storedItem{resp: resp, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// Set implements ResponseStore
+func (m *MemoryResponseStore) Set(key string, resp *StoredResponse, ttl time.Duration) error {
+	m.lock.Lock()
+	m.items[key] = storedItem{resp: resp, expires: time.Now().Add(ttl)}
+	m.lock.Unlock()
+	return nil
+}
+
+// Delete implements ResponseStore
+func (m *MemoryResponseStore) Delete(key string) error {
+	m.lock.Lock()
+	delete(m.items, key)
+	m.lock.Unlock()
+	return nil
+}
+
+// WithCache caches the results of a read-only route for 'ttl', only GET and
+// HEAD requests are cached, by the query string and the principal of the
+// caller. The responses carry an ETag, and requests with a matching
+// 'If-None-Match' header get a '304 Not Modified'.
+func WithCache(ttl time.Duration) RouteOption {
+	return func(r *route) {
+		r.cacheTTL = ttl
+	}
+}
+
+// cachedCall is the state of a call whose result is stored in the
+// ResponseStore, it is carried by the request context from serve to doCall
+type cachedCall struct {
+	s           *Server
+	name        string
+	contentType string // content type of the response, part of the key
+	ttl         time.Duration
+	idempotent  bool
+	key         string          // set if the result should be stored
+	hit         *StoredResponse // set if the result is in the store
+}
+
+type cachedCallKey struct{}
+
+// withCachedCall returns a copy of 'r' whose context carries a cachedCall,
+// if the result of the call could be stored, otherwise it returns nil
+func (s *Server) withCachedCall(name string, route *route, r *http.Request, codec Codec) (*http.Request, *cachedCall) {
+	if s.ResponseStore == nil {
+		return r, nil
+	}
+
+	cc := &cachedCall{s: s, name: name, contentType: codecContentType(codec)}
+	if r.Method == "GET" || r.Method == "HEAD" {
+		if route.cacheTTL <= 0 {
+			return r, nil
+		}
+		cc.ttl = route.cacheTTL
+	} else {
+		if s.Idempotency == nil || len(r.Header.Get(IdempotencyHeader)) == 0 {
+			return r, nil
+		}
+		cc.idempotent = true
+		if cc.ttl = s.Idempotency.TTL; cc.ttl <= 0 {
+			cc.ttl = 24 * time.Hour
+		}
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), cachedCallKey{}, cc)), cc
+}
+
+// lookup looks up the result of the call in the store, it is called after
+// authentication, so that the key contains the principal. It returns true
+// if the result is found, and an error if an idempotent call with the same
+// key is in progress. The call proceeds without the store if it fails.
+func (cc *cachedCall) lookup(r *http.Request) (bool, error) {
+	var key string
+	if cc.idempotent {
+		scope := KeyByPrincipal
+		if cc.s.Idempotency.Key != nil {
+			scope = cc.s.Idempotency.Key
+		}
+		key = "idempotency\x00" + cc.name + "\x00" + scope(r) + "\x00" + r.Header.Get(IdempotencyHeader)
+	} else {
+		var principal string
+		if p := PrincipalFromContext(r.Context()); p != nil {
+			principal = p.Scheme + ":" + p.ID
+		}
+		key = "cache\x00" + cc.name + "\x00" + principal + "\x00" + r.URL.RawQuery
+	}
+	key += "\x00" + cc.contentType
+
+	if !cc.idempotent {
+		resp, e := cc.s.ResponseStore.Get(key)
+		if e != nil {
+			return false, nil
+		}
+		if resp == nil {
+			cc.key = key
+			return false, nil
+		}
+		cc.hit = resp
+		return true, nil
+	}
+
+	ok, e := cc.s.ResponseStore.Add(key, &StoredResponse{}, idempotencyLockTTL)
+	if e != nil {
+		return false, nil
+	}
+	if ok {
+		cc.key = key
+		return false, nil
+	}
+
+	resp, e := cc.s.ResponseStore.Get(key)
+	if e != nil || resp == nil {
+		// the lock expired just now, run the call without storing it
+		return false, nil
+	}
+	if resp.Status == 0 {
+		return false, NewError(CodeConflict, http.StatusConflict, "a call with the same idempotency key is in progress")
+	}
+	cc.hit = resp
+	return true, nil
+}
+
+// isTransient reports whether 'e' is a transient error, the results of
+// idempotent calls which fail with such errors are not stored, so that
+// the calls can be retried
+func isTransient(e error) bool {
+	re := toError(e)
+	switch re.Code {
+	case CodeUnavailable, CodeDeadlineExceeded, CodeCanceled, CodeResourceExhausted, CodeInternal:
+		return true
+	}
+	return re.Status >= http.StatusInternalServerError
+}
+
+// write writes the result of the call, it stores the result if it is not
+// from the store
+func (cc *cachedCall) write(w http.ResponseWriter, r *http.Request, codec Codec, res interface{}, e error) {
+	resp := cc.hit
+	if resp == nil {
+		if len(cc.key) == 0 {
+			writeResult(w, codec, res, e)
+			return
+		}
+
+		if cc.idempotent && e != nil && isTransient(e) {
+			cc.s.ResponseStore.Delete(cc.key)
+			writeResult(w, codec, res, e)
+			return
+		}
+		if !cc.idempotent && e != nil {
+			writeResult(w, codec, res, e)
+			return
+		}
+
+		result, status := newResult(res, e)
+		var buf bytes.Buffer
+		codec.Encode(&buf, result)
+		resp = &StoredResponse{Status: status, ContentType: cc.contentType, Body: buf.Bytes()}
+		if !cc.idempotent {
+			sum := sha1.Sum(resp.Body)
+			resp.ETag = `"` + hex.EncodeToString(sum[:]) + `"`
+		}
+		cc.s.ResponseStore.Set(cc.key, resp, cc.ttl)
+	} else if cc.idempotent {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+
+	h := w.Header()
+	if len(resp.ETag) > 0 {
+		h.Set("ETag", resp.ETag)
+		h.Set("Cache-Control", "private, max-age="+strconv.FormatInt(int64(cc.ttl/time.Second), 10))
+		if etagMatch(r.Header.Get("If-None-Match"), resp.ETag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	h.Set("Content-Type", resp.ContentType)
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// etagMatch reports whether the value of an 'If-None-Match' header matches
+// 'etag'
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a copy of 'ctx' which carries idempotency key
+// 'key', calls made by a Client with the context send the key to the
+// server, and they are retried like the calls to idempotent routes
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// idempotencyKeyFromContext returns the idempotency key carried by 'ctx'
+func idempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
@@ -0,0 +1,168 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Idempotency(t *testing.T) {
+	s := NewServer("/")
+	s.Idempotency = &Idempotency{}
+	orders, fails := 0, 1
+	s.Add("POST", "order", func(r *http.Request) (int, error) {
+		if fails > 0 {
+			fails--
+			return 0, NewError(CodeUnavailable, http.StatusServiceUnavailable, "try again")
+		}
+		orders++
+		return orders, nil
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	c.Retry.MaxAttempts = 2
+	ctx := WithIdempotencyKey(context.Background(), "k1")
+	for i := 0; i < 3; i++ {
+		var res int
+		if e := c.Call(ctx, "order", nil, &res); e != nil || res != 1 {
+			t.Errorf("unexpected result %d, %v", res, e)
+		}
+	}
+
+	var res int
+	if e := c.Call(WithIdempotencyKey(context.Background(), "k2"), "order", nil, &res); e != nil || res != 2 {
+		t.Errorf("unexpected result %d, %v", res, e)
+	}
+	if orders != 2 {
+		t.Errorf("expect 2 orders, got %d", orders)
+	}
+}
+
+func Test_IdempotencyDisabled(t *testing.T) {
+	s := NewServer("/")
+	calls := 0
+	s.Add("POST", "order", func(r *http.Request) (int, error) {
+		calls++
+		return calls, nil
+	})
+
+	for i := 1; i <= 2; i++ {
+		r := httptest.NewRequest("POST", "/order", nil)
+		r.Header.Set(IdempotencyHeader, "k")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), fmt.Sprintf(`"data":%d`, i)) || len(w.Header().Get("Idempotent-Replayed")) > 0 {
+			t.Errorf("unexpected response %v '%s'", w.Header(), w.Body.String())
+		}
+	}
+}
+
+func Test_IdempotencyConflict(t *testing.T) {
+	s := NewServer("/")
+	s.Idempotency = &Idempotency{}
+	started, release := make(chan struct{}), make(chan struct{})
+	s.Add("POST", "slow", func(r *http.Request) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	call := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/slow", nil)
+		r.Header.Set(IdempotencyHeader, "k")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- call() }()
+	<-started
+
+	if w := call(); w.Code != http.StatusConflict {
+		t.Errorf("expect status 409, got %d", w.Code)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Errorf("expect status 200, got %d", w.Code)
+	}
+	if w := call(); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("unexpected response %d, %v", w.Code, w.Header())
+	}
+}
+
+func Test_IdempotentBatch(t *testing.T) {
+	s := newTestServer("/")
+	s.Idempotency = &Idempotency{}
+	s.AddBatch("batch", 2)
+
+	call := func() *httptest.ResponseRecorder {
+		body := `[{"route":"echo","arg":{"text":"a"}},{"route":"ping"}]`
+		r := httptest.NewRequest("POST", "/batch", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(IdempotencyHeader, "k")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	expect := `{"succeeded":true,"data":[{"succeeded":true,"data":"a"},{"succeeded":true}]}`
+	w := call()
+	if strings.TrimSpace(w.Body.String()) != expect {
+		t.Errorf("unexpected response '%s'", w.Body.String())
+	}
+	if w = call(); strings.TrimSpace(w.Body.String()) != expect || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("unexpected response %v '%s'", w.Header(), w.Body.String())
+	}
+}
+
+func Test_Cache(t *testing.T) {
+	s := NewServer("/")
+	calls := 0
+	s.Add("GET", "count", func(r *http.Request) (int, error) {
+		calls++
+		if calls > 2 {
+			return 0, errors.New("failed")
+		}
+		return calls, nil
+	}, WithCache(time.Minute))
+
+	get := func(query, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/count"+query, nil)
+		if len(etag) > 0 {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || len(etag) == 0 || !strings.Contains(w.Body.String(), `"data":1`) {
+		t.Fatalf("unexpected response %d, %v, %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	if w = get("", ""); w.Header().Get("ETag") != etag || !strings.Contains(w.Body.String(), `"data":1`) {
+		t.Errorf("result is not cached: %s", w.Body.String())
+	}
+	if w = get("", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expect status 304, got %d", w.Code)
+	}
+	if w = get("?a=1", etag); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"data":2`) {
+		t.Errorf("unexpected response %d, %s", w.Code, w.Body.String())
+	}
+	if w = get("?a=2", ""); len(w.Header().Get("ETag")) > 0 {
+		t.Error("errors should not be cached")
+	}
+	if calls != 3 {
+		t.Errorf("expect 3 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,373 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retry configures the retry of idempotent calls, a call is retried if
+// there's a network error, or the server responds with status 502, 503 or
+// 504
+type Retry struct {
+	MaxAttempts int           // including the first attempt, less than 2 disables retry
+	MinBackoff  time.Duration // backoff before the first retry, default is 100ms
+	MaxBackoff  time.Duration // maximal backoff, default is 10s
+}
+
+// backoff returns the time to wait before the n-th retry, it doubles on
+// every retry, with a random jitter
+func (r *Retry) backoff(n int) time.Duration {
+	min, max := r.MinBackoff, r.MaxBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+
+	d := min
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// ErrCircuitOpen is returned when a call is rejected by an open Breaker
+var ErrCircuitOpen = NewError(CodeUnavailable, http.StatusServiceUnavailable, "circuit breaker is open")
+
+// Breaker is a circuit breaker, it opens after 'Threshold' consecutive
+// failures, and then rejects calls with ErrCircuitOpen. After 'Cooldown',
+// it allows one trial call, and closes if the call succeeds.
+// Only network errors and responses with status 5xx are failures.
+type Breaker struct {
+	Threshold int           // default is 5
+	Cooldown  time.Duration // default is 30s
+
+	lock     sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool // a trial call is in progress
+}
+
+func (b *Breaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	threshold, cooldown := b.Threshold, b.Cooldown
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+
+	if b.failures < threshold {
+		return true
+	}
+	if b.trial || time.Since(b.openedAt) < cooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *Breaker) record(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	threshold := b.Threshold
+	if threshold <= 0 {
+		threshold = 5
+	}
+
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// Client calls the routes of a server
+type Client struct {
+	BaseURL      string              // URL prefix of the routes
+	HTTPClient   *http.Client        // default is http.DefaultClient
+	Header       http.Header         // default headers of every call
+	Timeout      time.Duration       // timeout of every call, including retries, 0 means no timeout
+	Retry        Retry               // retry policy of idempotent routes
+	Idempotent   map[string]bool     // names of idempotent routes, only they and calls with WithIdempotencyKey are retried
+	Breaker      *Breaker            // optional circuit breaker
+	Interceptors []ClientInterceptor // the first one is the outermost
+	Codec        Codec               // codec of arguments and results, default is JSONCodec
+}
+
+// NewClient creates a new client
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+// Use appends interceptors to the client, it should only be called at
+// initialization time
+func (c *Client) Use(interceptors ...ClientInterceptor) {
+	c.Interceptors = append(c.Interceptors, interceptors...)
+}
+
+// Call calls 'route' with 'arg', and decodes the result into 'result'
+func (c *Client) Call(ctx context.Context, route string, arg, result interface{}) error {
+	return c.CallMethod(ctx, "POST", route, arg, result)
+}
+
+// CallMethod is Call with HTTP method 'method', the argument of a GET or
+// HEAD call is encoded into the query string, see EncodeValues. GET and
+// HEAD calls are retried like the calls to idempotent routes.
+func (c *Client) CallMethod(ctx context.Context, method, route string, arg, result interface{}) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	cc := &ClientCall{
+		Context: ctx,
+		Method:  method,
+		Route:   route,
+		URL:     c.BaseURL + route,
+		Arg:     arg,
+		Result:  result,
+		Header:  make(http.Header),
+	}
+	for k, v := range c.Header {
+		cc.Header[k] = v
+	}
+
+	return chainClientInterceptors(c.Interceptors, c.invoke)(cc)
+}
+
+// invoke sends the call, with the circuit breaker and retries
+func (c *Client) invoke(cc *ClientCall) error {
+	attempts := 1
+	idempotent := c.Idempotent[cc.Route] || len(idempotencyKeyFromContext(cc.Context)) > 0 || !hasBody(cc.Method)
+	if idempotent && c.Retry.MaxAttempts > 1 {
+		attempts = c.Retry.MaxAttempts
+	}
+
+	for i := 1; ; i++ {
+		if c.Breaker != nil && !c.Breaker.allow() {
+			return ErrCircuitOpen
+		}
+
+		e := c.send(cc)
+		failed := isServerFailure(e)
+		if c.Breaker != nil {
+			c.Breaker.record(failed)
+		}
+
+		if !failed || i >= attempts || cc.Context.Err() != nil {
+			return e
+		}
+
+		select {
+		case <-cc.Context.Done():
+			return e
+		case <-time.After(c.Retry.backoff(i)):
+		}
+	}
+}
+
+// isServerFailure reports whether 'e' is a network error or a server
+// failure, these errors are retried and counted by the circuit breaker
+func isServerFailure(e error) bool {
+	if e == nil || errors.Is(e, context.Canceled) || errors.Is(e, context.DeadlineExceeded) {
+		return false
+	}
+
+	var re *Error
+	if !errors.As(e, &re) {
+		return true // network error
+	}
+
+	switch re.Status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// hasBody reports whether the argument of a call with HTTP method 'method'
+// is sent in the body, an empty method means POST
+func hasBody(method string) bool {
+	return method != "GET" && method != "HEAD"
+}
+
+// newRequest creates the HTTP request of a call, the argument is encoded
+// with 'codec', or into the query string for GET and HEAD calls
+func newRequest(cc *ClientCall, codec Codec) (*http.Request, error) {
+	method, url := cc.Method, cc.URL
+	if len(method) == 0 {
+		method = "POST"
+	}
+
+	var data bytes.Buffer
+	if cc.Arg != nil && hasBody(method) {
+		if e := codec.Encode(&data, cc.Arg); e != nil {
+			return nil, e
+		}
+	} else if cc.Arg != nil {
+		values, e := EncodeValues(cc.Arg)
+		if e != nil {
+			return nil, e
+		}
+		if len(values) > 0 {
+			sep := "?"
+			if strings.Contains(url, "?") {
+				sep = "&"
+			}
+			url += sep + values.Encode()
+		}
+	}
+
+	return http.NewRequestWithContext(cc.Context, method, url, &data)
+}
+
+// send sends one HTTP request for the call
+func (c *Client) send(cc *ClientCall) error {
+	codec := c.Codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	req, e := newRequest(cc, codec)
+	if e != nil {
+		return e
+	}
+	for k, v := range cc.Header {
+		req.Header[k] = v
+	}
+	if codec == JSONCodec {
+		req.Header.Set("Content-Type", contentType)
+	} else {
+		req.Header.Set("Content-Type", codec.ContentType())
+		req.Header.Set("Accept", codec.ContentType())
+	}
+	setTimeoutHeader(cc.Context, req.Header)
+	setTraceHeader(cc.Context, req.Header)
+	if key := idempotencyKeyFromContext(cc.Context); len(key) > 0 {
+		req.Header.Set(IdempotencyHeader, key)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	resp, e := hc.Do(req)
+	if e != nil {
+		return e
+	}
+	defer func() {
+		// drain the body, so that the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if codec == JSONCodec {
+		return decodeResult(resp, cc.Result)
+	}
+	return decodeCodecResult(codec, resp, cc.Result)
+}
+
+// rawResult is used to decode the data of a result according to the
+// result is succeeded or not
+type rawResult struct {
+	Succeeded bool            `json:"succeeded"`
+	Code      string          `json:"code"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// decodeResult decodes the data of a succeeded result into 'result', or
+// returns an *Error for a failed result
+func decodeResult(resp *http.Response, result interface{}) error {
+	var r rawResult
+	if e := json.NewDecoder(resp.Body).Decode(&r); e != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &Error{Status: resp.StatusCode, Message: resp.Status}
+		}
+		return e
+	}
+
+	return r.decode(result, resp.StatusCode)
+}
+
+// decode decodes the data of a succeeded result into 'result', or returns
+// an *Error for a failed result, 'status' is the HTTP status of the result
+func (r *rawResult) decode(result interface{}, status int) error {
+	if !r.Succeeded {
+		re := &Error{Code: r.Code, Status: status, Message: r.Message}
+		if len(r.Data) > 0 && string(r.Data) != "null" {
+			re.Details = r.Data
+		}
+		return re
+	}
+
+	if result == nil || len(r.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Data, result)
+}
+
+// decodeCodecResult is decodeResult for non-JSON codecs, the data of the
+// result is decoded as a generic value, and then re-encoded and decoded
+// into 'result'
+func decodeCodecResult(codec Codec, resp *http.Response, result interface{}) error {
+	var r Result
+	if e := codec.Decode(resp.Body, &r); e != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &Error{Status: resp.StatusCode, Message: resp.Status}
+		}
+		return e
+	}
+
+	if !r.Succeeded {
+		return &Error{Code: r.Code, Status: resp.StatusCode, Message: r.Message, Details: r.Data}
+	}
+	if result == nil || r.Data == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if e := codec.Encode(&buf, r.Data); e != nil {
+		return e
+	}
+	return codec.Decode(&buf, result)
+}
+
+// DefaultClient is the client used by Call and CallContext, its BaseURL
+// should be empty, as they accept full URLs
+var DefaultClient = &Client{}
+
+// Call calls the specified API
+func Call(url string, arg, result interface{}) error {
+	return DefaultClient.Call(context.Background(), url, arg, result)
+}
+
+// CallContext calls the specified API with a context, the deadline of the
+// context is propagated to the server
+func CallContext(ctx context.Context, url string, arg, result interface{}) error {
+	return DefaultClient.Call(ctx, url, arg, result)
+}
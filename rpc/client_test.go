@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ClientRetry(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeResult(w, JSONCodec, r.URL.Path, nil)
+	}))
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	c.Retry = Retry{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	c.Idempotent = map[string]bool{"get": true}
+
+	var res string
+	if e := c.Call(context.Background(), "get", nil, &res); e != nil {
+		t.Fatal(e)
+	}
+	if res != "/get" || count != 3 {
+		t.Errorf("unexpected result '%s' after %d attempts", res, count)
+	}
+
+	// not idempotent, no retry
+	count = 0
+	if e := c.Call(context.Background(), "post", nil, &res); e == nil {
+		t.Error("non-idempotent route is retried")
+	}
+	if count != 1 {
+		t.Errorf("expect 1 attempt, got %d", count)
+	}
+}
+
+func Test_ClientBreaker(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	c.Breaker = &Breaker{Threshold: 2, Cooldown: 50 * time.Millisecond}
+
+	for i := 0; i < 4; i++ {
+		c.Call(context.Background(), "x", nil, nil)
+	}
+	if count != 2 {
+		t.Errorf("expect 2 requests before the breaker opens, got %d", count)
+	}
+	if e := c.Call(context.Background(), "x", nil, nil); !errors.Is(e, ErrCircuitOpen) {
+		t.Errorf("expect ErrCircuitOpen, got %v", e)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	c.Call(context.Background(), "x", nil, nil)
+	if count != 3 {
+		t.Errorf("trial call is not sent after cooldown")
+	}
+}
+
+func Test_ClientGet(t *testing.T) {
+	s := NewServer("/")
+	s.Add("GET", "get", func(r *http.Request, arg *codecArg) (*codecArg, error) {
+		return arg, nil
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	on := true
+	in := &codecArg{
+		codecInner: codecInner{Tags: []string{"a", "b"}},
+		Name:       "get",
+		Count:      -3,
+		Ratio:      0.25,
+		On:         &on,
+		Time:       time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Raw:        []byte("raw"),
+	}
+
+	c := NewClient(ts.URL + "/")
+	var out codecArg
+	if e := c.CallMethod(context.Background(), "GET", "get", in, &out); e != nil {
+		t.Fatal(e)
+	}
+	if out.Name != in.Name || out.Count != in.Count || out.Ratio != in.Ratio || !out.Time.Equal(in.Time) ||
+		len(out.Tags) != 2 || out.On == nil || !*out.On || string(out.Raw) != "raw" {
+		t.Errorf("expect %+v, got %+v", in, out)
+	}
+
+	var re *Error
+	if e := c.Call(context.Background(), "get", in, &out); !errors.As(e, &re) || re.Code != CodeMethodNotAllowed {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
@@ -0,0 +1,441 @@
+// Package clientgen generates typed clients from the description of rpc
+// routes, see rpc.Describe
+package clientgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/localvar/go-utils/rpc"
+)
+
+// identifier converts a route name, like 'user/get_info', to an exported
+// Go identifier, like 'UserGetInfo'
+func identifier(name string) string {
+	var sb strings.Builder
+	upper := true
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		sb.WriteRune(c)
+	}
+
+	s := sb.String()
+	if len(s) == 0 || unicode.IsDigit(rune(s[0])) {
+		s = "R" + s
+	}
+	return s
+}
+
+// checkIdentifiers returns an error if the identifiers of two routes are
+// the same, like those of 'user/get' and 'user_get'
+func checkIdentifiers(desc *rpc.APIDesc) error {
+	routes := make(map[string]string, len(desc.Routes))
+	for i := range desc.Routes {
+		name := desc.Routes[i].Name
+		id := identifier(name)
+		if other, ok := routes[id]; ok {
+			return fmt.Errorf("routes '%s' and '%s' have the same identifier '%s'", other, name, id)
+		}
+		routes[id] = name
+	}
+	return nil
+}
+
+// lowerFirst converts 'UserGetInfo' to 'userGetInfo'
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func sortedTypeNames(desc *rpc.APIDesc) []string {
+	names := make([]string, 0, len(desc.Types))
+	for name := range desc.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func routeMethod(r *rpc.RouteDesc) string {
+	if len(r.Method) == 0 {
+		return "POST"
+	}
+	return r.Method
+}
+
+type goGen struct {
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+func (g *goGen) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *goGen) typeExpr(td *rpc.TypeDesc) string {
+	var s string
+	pointer := td.Nullable
+
+	switch td.Kind {
+	case "bytes":
+		return "[]byte"
+	case "any":
+		return "interface{}"
+	case "array":
+		return "[]" + g.typeExpr(td.Elem)
+	case "map":
+		return "map[string]" + g.typeExpr(td.Elem)
+	case "time":
+		g.imports["time"] = true
+		s = "time.Time"
+	case "ref":
+		s = td.Name
+	case "struct":
+		var sb strings.Builder
+		sb.WriteString("struct {\n")
+		for i := range td.Fields {
+			sb.WriteString(g.field(&td.Fields[i]))
+		}
+		sb.WriteString("}")
+		s = sb.String()
+	default:
+		s = td.Kind
+	}
+
+	if pointer {
+		s = "*" + s
+	}
+	return s
+}
+
+func (g *goGen) field(f *rpc.FieldDesc) string {
+	tag := f.Name
+	if f.Optional {
+		tag += ",omitempty"
+	}
+	if f.Type.String {
+		tag += ",string"
+	}
+	return fmt.Sprintf("%s %s `json:\"%s\"`\n", f.GoName, g.typeExpr(f.Type), tag)
+}
+
+func (g *goGen) route(r *rpc.RouteDesc) {
+	name := identifier(r.Name)
+
+	params := "ctx context.Context"
+	arg := "nil"
+	if r.Arg != nil {
+		t := g.typeExpr(r.Arg)
+		if r.Arg.Kind == "ref" && !r.Arg.Nullable {
+			t = "*" + t
+		}
+		params, arg = params+", arg "+t, "arg"
+	}
+
+	// routes which are not POST are called with their method, the argument
+	// of a GET route is sent in the query string
+	call, stream := "Call(ctx, ", "Stream(ctx, "
+	if m := routeMethod(r); m != "POST" {
+		call = fmt.Sprintf("CallMethod(ctx, %q, ", m)
+		stream = fmt.Sprintf("StreamMethod(ctx, %q, ", m)
+	}
+
+	g.printf("// %s calls route '%s'\n", name, r.Name)
+	if r.Deprecated {
+		g.printf("//\n// Deprecated: route '%s' is deprecated\n", r.Name)
+	}
+
+	if r.Stream {
+		g.printf("func (c *Client) %s(%s) (*rpc.StreamReader, error) {\n", name, params)
+		g.printf("return c.RPC.%s%q, %s)\n}\n\n", stream, r.Name, arg)
+		return
+	}
+
+	if r.Result == nil {
+		g.printf("func (c *Client) %s(%s) error {\n", name, params)
+		g.printf("return c.RPC.%s%q, %s, nil)\n}\n\n", call, r.Name, arg)
+		return
+	}
+
+	if r.Result.String {
+		// int64 and uint64 results are sent as strings
+		g.imports["strconv"] = true
+		parse := "ParseInt"
+		if strings.HasPrefix(r.Result.Kind, "u") {
+			parse = "ParseUint"
+		}
+		g.printf("func (c *Client) %s(%s) (%s, error) {\n", name, params, r.Result.Kind)
+		g.printf("var s string\n")
+		// a null result, e.g. a nil pointer, is 0
+		g.printf("if e := c.RPC.%s%q, %s, &s); e != nil || len(s) == 0 {\nreturn 0, e\n}\n", call, r.Name, arg)
+		g.printf("return strconv.%s(s, 10, 64)\n}\n\n", parse)
+		return
+	}
+
+	t := g.typeExpr(r.Result)
+	g.printf("func (c *Client) %s(%s) (%s, error) {\n", name, params, t)
+	g.printf("var res %s\n", t)
+	g.printf("e := c.RPC.%s%q, %s, &res)\n", call, r.Name, arg)
+	g.printf("return res, e\n}\n\n")
+}
+
+// Go writes a Go client of the routes in 'desc' to 'w', 'pkg' is the
+// package name of the generated file
+func Go(w io.Writer, desc *rpc.APIDesc, pkg string) error {
+	if e := checkIdentifiers(desc); e != nil {
+		return e
+	}
+
+	g := goGen{imports: map[string]bool{"context": true}}
+
+	for _, name := range sortedTypeNames(desc) {
+		td := desc.Types[name]
+		g.printf("// %s is generated from the Go type with the same name\n", name)
+		g.printf("type %s struct {\n", name)
+		for i := range td.Fields {
+			g.printf("%s", g.field(&td.Fields[i]))
+		}
+		g.printf("}\n\n")
+	}
+
+	g.printf("// Client calls the routes of the API\n")
+	g.printf("type Client struct {\n")
+	g.printf("RPC *rpc.Client\n")
+	g.printf("}\n\n")
+	g.printf("// NewClient creates a new client, 'baseURL' is the URL prefix of the routes\n")
+	g.printf("func NewClient(baseURL string) *Client {\n")
+	g.printf("return &Client{RPC: rpc.NewClient(baseURL)}\n")
+	g.printf("}\n\n")
+
+	for i := range desc.Routes {
+		g.route(&desc.Routes[i])
+	}
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "// Code generated by rpcgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&head, "package %s\n\nimport (\n", pkg)
+	var imports []string
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(&head, "%q\n", imp)
+	}
+	fmt.Fprintf(&head, "\n\"github.com/localvar/go-utils/rpc\"\n)\n\n")
+
+	src, e := format.Source(append(head.Bytes(), g.buf.Bytes()...))
+	if e != nil {
+		return e
+	}
+	_, e = w.Write(src)
+	return e
+}
+
+func tsType(td *rpc.TypeDesc) string {
+	var s string
+
+	switch td.Kind {
+	case "bool":
+		s = "boolean"
+	case "string", "bytes", "time":
+		s = "string"
+	case "any":
+		return "any"
+	case "array":
+		s = tsType(td.Elem)
+		if strings.Contains(s, " ") {
+			s = "(" + s + ")"
+		}
+		s += "[]"
+	case "map":
+		s = "{ [key: string]: " + tsType(td.Elem) + " }"
+	case "struct":
+		var sb strings.Builder
+		sb.WriteString("{ ")
+		for i := range td.Fields {
+			sb.WriteString(tsField(&td.Fields[i]))
+			sb.WriteString("; ")
+		}
+		sb.WriteString("}")
+		s = sb.String()
+	case "ref":
+		s = td.Name
+	default:
+		// numbers
+		s = "number"
+	}
+
+	if td.String {
+		s = "string"
+	}
+	if td.Nullable {
+		s += " | null"
+	}
+	return s
+}
+
+func tsField(f *rpc.FieldDesc) string {
+	name := f.Name
+	if f.Optional {
+		name += "?"
+	}
+	return name + ": " + tsType(f.Type)
+}
+
+const tsRuntime = `export interface Result<T> {
+  succeeded: boolean;
+  code?: string;
+  message?: string;
+  data?: T;
+}
+
+export class RpcError extends Error {
+  constructor(message: string, public code = "", public status = 0, public details?: unknown) {
+    super(message);
+    this.name = "RpcError";
+  }
+}
+
+export class Client {
+  constructor(public baseURL: string, public init: RequestInit = {}) {}
+
+  protected fetch(route: string, method: string, arg: unknown, accept: string): Promise<Response> {
+    const headers = new Headers(this.init.headers);
+    headers.set("Content-Type", "application/json; charset=utf-8");
+    headers.set("Accept", accept);
+    const hasBody = arg !== undefined && method !== "GET" && method !== "HEAD";
+    let url = this.baseURL + route;
+    if (arg !== undefined && !hasBody) {
+      // arguments of GET routes are sent in the query string
+      const query = new URLSearchParams();
+      for (const [k, v] of Object.entries(arg as Record<string, unknown>)) {
+        for (const x of Array.isArray(v) ? v : [v]) {
+          if (x !== undefined && x !== null) {
+            query.append(k, String(x));
+          }
+        }
+      }
+      url += "?" + query.toString();
+    }
+    return fetch(url, {
+      ...this.init,
+      method,
+      headers,
+      body: hasBody ? JSON.stringify(arg) : undefined,
+    });
+  }
+
+  protected async result<T>(resp: Response): Promise<T> {
+    let r: Result<T>;
+    try {
+      r = (await resp.json()) as Result<T>;
+    } catch (e) {
+      throw new RpcError(resp.statusText, "", resp.status);
+    }
+    if (!r.succeeded) {
+      throw new RpcError(r.message || "", r.code || "", resp.status, r.data);
+    }
+    return r.data as T;
+  }
+
+  protected async call<T>(route: string, method: string, arg?: unknown): Promise<T> {
+    const resp = await this.fetch(route, method, arg, "application/json");
+    return this.result<T>(resp);
+  }
+
+  // stream calls a streaming route, the results are newline-delimited JSON
+  protected async *stream<T>(route: string, method: string, arg?: unknown): AsyncGenerator<T> {
+    const resp = await this.fetch(route, method, arg, "application/x-ndjson");
+    if (!(resp.headers.get("Content-Type") || "").startsWith("application/x-ndjson") || !resp.body) {
+      await this.result<unknown>(resp);
+      throw new RpcError("route '" + route + "' is not a streaming route", "", resp.status);
+    }
+    const reader = resp.body.getReader();
+    const decoder = new TextDecoder();
+    let buf = "";
+    for (;;) {
+      const { done, value } = await reader.read();
+      if (done) {
+        throw new RpcError("unexpected end of stream", "", resp.status);
+      }
+      buf += decoder.decode(value, { stream: true });
+      let i: number;
+      while ((i = buf.indexOf("\n")) >= 0) {
+        const line = buf.slice(0, i).trim();
+        buf = buf.slice(i + 1);
+        if (line.length === 0) {
+          continue;
+        }
+        const r = JSON.parse(line) as Result<T>;
+        if (!r.succeeded) {
+          throw new RpcError(r.message || "", r.code || "", resp.status, r.data);
+        }
+        if (r.code === "stream_end") {
+          reader.cancel();
+          return;
+        }
+        yield r.data as T;
+      }
+    }
+  }
+`
+
+// TypeScript writes a TypeScript client of the routes in 'desc' to 'w',
+// it uses the fetch API
+func TypeScript(w io.Writer, desc *rpc.APIDesc) error {
+	if e := checkIdentifiers(desc); e != nil {
+		return e
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by rpcgen. DO NOT EDIT.\n\n")
+
+	for _, name := range sortedTypeNames(desc) {
+		td := desc.Types[name]
+		fmt.Fprintf(&buf, "export interface %s {\n", name)
+		for i := range td.Fields {
+			fmt.Fprintf(&buf, "  %s;\n", tsField(&td.Fields[i]))
+		}
+		buf.WriteString("}\n\n")
+	}
+
+	buf.WriteString(tsRuntime)
+	for i := range desc.Routes {
+		r := &desc.Routes[i]
+		params, arg := "", ""
+		if r.Arg != nil {
+			params, arg = "arg: "+tsType(r.Arg), ", arg"
+		}
+		name := lowerFirst(identifier(r.Name))
+		if r.Deprecated {
+			buf.WriteString("\n  /** @deprecated */")
+		}
+		if r.Stream {
+			fmt.Fprintf(&buf, "\n  %s(%s): AsyncGenerator<unknown> {\n", name, params)
+			fmt.Fprintf(&buf, "    return this.stream<unknown>(%q, %q%s);\n  }\n", r.Name, routeMethod(r), arg)
+			continue
+		}
+		res := "void"
+		if r.Result != nil {
+			res = tsType(r.Result)
+		}
+		fmt.Fprintf(&buf, "\n  %s(%s): Promise<%s> {\n", name, params, res)
+		fmt.Fprintf(&buf, "    return this.call<%s>(%q, %q%s);\n  }\n", res, r.Name, routeMethod(r), arg)
+	}
+	buf.WriteString("}\n")
+
+	_, e := w.Write(buf.Bytes())
+	return e
+}
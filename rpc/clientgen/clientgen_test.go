@@ -0,0 +1,150 @@
+package clientgen
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/localvar/go-utils/rpc"
+)
+
+type Address struct {
+	City string `json:"city"`
+}
+
+type User struct {
+	ID       int64     `json:"id,string"`
+	Name     string    `json:"name"`
+	Tags     []string  `json:"tags,omitempty"`
+	Address  *Address  `json:"address"`
+	Friends  []*User   `json:"friends"`
+	Birthday time.Time `json:"birthday"`
+	secret   string
+}
+
+func init() {
+	rpc.Add("POST", "user/get", func(r *http.Request, arg *rpc.IDArg) (*User, error) {
+		return nil, nil
+	})
+	rpc.Add("POST", "user/count", func(r *http.Request) (int64, error) {
+		return 0, nil
+	})
+	rpc.Add("GET", "user/find", func(r *http.Request, arg *rpc.IDArg) (*User, error) {
+		return nil, nil
+	})
+	rpc.Add("", "user/delete", func(r *http.Request, arg *rpc.IDArg) error {
+		return nil
+	})
+}
+
+func Test_Go(t *testing.T) {
+	var buf bytes.Buffer
+	if e := Go(&buf, rpc.Describe(), "api"); e != nil {
+		t.Fatal(e)
+	}
+
+	src := buf.String()
+	for _, s := range []string{
+		"ID int64 `json:\"id,string\"`",
+		"Friends  []*User",
+		"func (c *Client) UserGet(ctx context.Context, arg *IDArg) (*User, error)",
+		"func (c *Client) UserCount(ctx context.Context) (int64, error)",
+		"func (c *Client) UserDelete(ctx context.Context, arg *IDArg) error",
+		`e := c.RPC.CallMethod(ctx, "GET", "user/find", arg, &res)`,
+		`e := c.RPC.Call(ctx, "user/get", arg, &res)`,
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated Go client does not contain %q", s)
+		}
+	}
+	if strings.Contains(src, "secret") {
+		t.Error("unexported field is generated")
+	}
+}
+
+func Test_TypeScript(t *testing.T) {
+	var buf bytes.Buffer
+	if e := TypeScript(&buf, rpc.Describe()); e != nil {
+		t.Fatal(e)
+	}
+
+	src := buf.String()
+	for _, s := range []string{
+		"id: string;",
+		"tags?: string[] | null;",
+		"address: Address | null;",
+		"userGet(arg: IDArg): Promise<User | null>",
+		"userCount(): Promise<string>",
+		`return this.call<void>("user/delete", "POST", arg);`,
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated TypeScript client does not contain %q", s)
+		}
+	}
+}
+
+func Test_IdentifierCollision(t *testing.T) {
+	desc := &rpc.APIDesc{Routes: []rpc.RouteDesc{{Name: "a.b"}, {Name: "a_b"}}}
+	if e := Go(&bytes.Buffer{}, desc, "api"); e == nil || !strings.Contains(e.Error(), "'AB'") {
+		t.Errorf("unexpected error: %v", e)
+	}
+	if e := TypeScript(&bytes.Buffer{}, desc); e == nil {
+		t.Error("identifier collision is not detected")
+	}
+}
+
+type UserID int64
+
+func Test_Int64Result(t *testing.T) {
+	const id = 1<<60 + 1
+	s := rpc.NewServer("/")
+	s.Add("POST", "id", func(r *http.Request) (UserID, error) {
+		return id, nil
+	})
+	s.Add("POST", "ptr", func(r *http.Request) (*uint64, error) {
+		v := uint64(id)
+		return &v, nil
+	})
+	s.Add("POST", "nil", func(r *http.Request) (*int64, error) {
+		return nil, nil
+	})
+
+	var buf bytes.Buffer
+	if e := Go(&buf, s.Describe(), "api"); e != nil {
+		t.Fatal(e)
+	}
+	src := buf.String()
+	for _, s := range []string{
+		"func (c *Client) Id(ctx context.Context) (int64, error)",
+		"return strconv.ParseInt(s, 10, 64)",
+		"func (c *Client) Ptr(ctx context.Context) (uint64, error)",
+		"return strconv.ParseUint(s, 10, 64)",
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated Go client does not contain %q", s)
+		}
+	}
+
+	// call the routes like the generated client does
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := rpc.NewClient(ts.URL + "/")
+	for _, route := range []string{"id", "ptr", "nil"} {
+		var res string
+		if e := c.Call(context.Background(), route, nil, &res); e != nil {
+			t.Fatalf("%s: %v", route, e)
+		}
+		expect := strconv.Itoa(id)
+		if route == "nil" {
+			expect = ""
+		}
+		if res != expect {
+			t.Errorf("%s: expect '%s', got '%s'", route, expect, res)
+		}
+	}
+}
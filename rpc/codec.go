@@ -0,0 +1,303 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Codec encodes and decodes arguments and results
+type Codec interface {
+	// ContentType returns the media type of the codec, without parameters
+	ContentType() string
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+// ErrNotEncodable is returned by codecs which can only decode, like the
+// form codec
+var ErrNotEncodable = errors.New("codec cannot encode")
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// Built-in codecs
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgPackCodec Codec = msgpackCodec{}
+	FormCodec    Codec = formCodec{}
+)
+
+// DefaultCodecs are the codecs used by a server whose 'Codecs' is nil
+var DefaultCodecs = []Codec{JSONCodec, MsgPackCodec, FormCodec}
+
+func (s *Server) codecs() []Codec {
+	if s.Codecs == nil {
+		return DefaultCodecs
+	}
+	return s.Codecs
+}
+
+func findCodec(codecs []Codec, mediaType string) Codec {
+	for _, c := range codecs {
+		if c.ContentType() == mediaType {
+			return c
+		}
+	}
+	return nil
+}
+
+// requestCodec returns the codec of the request body according to its
+// Content-Type, JSON is used if there's no Content-Type
+func (s *Server) requestCodec(r *http.Request) (Codec, error) {
+	ct := r.Header.Get("Content-Type")
+	if len(ct) == 0 {
+		return JSONCodec, nil
+	}
+
+	mt, _, e := mime.ParseMediaType(ct)
+	if e != nil {
+		return nil, NewError(CodeInvalidArgument, http.StatusUnsupportedMediaType, e.Error())
+	}
+	if c := findCodec(s.codecs(), mt); c != nil {
+		return c, nil
+	}
+
+	msg := "unsupported content type '" + mt + "'"
+	return nil, NewError(CodeInvalidArgument, http.StatusUnsupportedMediaType, msg)
+}
+
+// responseCodec returns the codec of the response according to the Accept
+// header, the codec of the request is preferred if it is acceptable and
+// can encode, JSON is the fallback
+func (s *Server) responseCodec(r *http.Request, reqCodec Codec) Codec {
+	type accept struct {
+		mediaType string
+		q         float64
+	}
+
+	var accepts []accept
+	for _, v := range r.Header["Accept"] {
+		for _, item := range strings.Split(v, ",") {
+			mt, params, e := mime.ParseMediaType(strings.TrimSpace(item))
+			if e != nil {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if f, e := strconv.ParseFloat(v, 64); e == nil {
+					q = f
+				}
+			}
+			if q > 0 {
+				accepts = append(accepts, accept{mt, q})
+			}
+		}
+	}
+	sort.SliceStable(accepts, func(i, j int) bool {
+		return accepts[i].q > accepts[j].q
+	})
+
+	canEncode := func(c Codec) bool {
+		return c != nil && c != FormCodec
+	}
+
+	for _, a := range accepts {
+		if a.mediaType == "*/*" || a.mediaType == "application/*" {
+			if canEncode(reqCodec) {
+				return reqCodec
+			}
+			return JSONCodec
+		}
+		if c := findCodec(s.codecs(), a.mediaType); canEncode(c) {
+			return c
+		}
+	}
+
+	if len(accepts) == 0 && canEncode(reqCodec) {
+		return reqCodec
+	}
+	return JSONCodec
+}
+
+func writeContentType(w http.ResponseWriter, c Codec) {
+	w.Header().Set("Content-Type", codecContentType(c))
+}
+
+// codecContentType returns the content type of the responses encoded by 'c'
+func codecContentType(c Codec) string {
+	if c == JSONCodec {
+		return contentType
+	}
+	return c.ContentType()
+}
+
+// structField is a field of a struct, which is encoded with the JSON name
+// by non-JSON codecs
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var structFieldsCache sync.Map
+
+// structFields returns the fields of struct type 't' as encoding/json sees
+// them, fields of embedded structs are promoted
+func structFields(t reflect.Type) []structField {
+	if v, ok := structFieldsCache.Load(t); ok {
+		return v.([]structField)
+	}
+
+	var fields []structField
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+
+			name, opts := tag, ""
+			if i := strings.IndexByte(tag, ','); i >= 0 {
+				name, opts = tag[:i], tag[i:]
+			}
+
+			idx := append(append([]int(nil), index...), i)
+			if f.Anonymous && len(name) == 0 {
+				ft := f.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft, idx)
+					continue
+				}
+			}
+			if len(f.PkgPath) > 0 {
+				continue
+			}
+			if len(name) == 0 {
+				name = f.Name
+			}
+			fields = append(fields, structField{
+				name:      name,
+				index:     idx,
+				omitEmpty: strings.Contains(opts, ",omitempty"),
+			})
+		}
+	}
+	walk(t, nil)
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+// errEmbeddedPtr is returned when decoding a field promoted from an embedded
+// nil pointer to an unexported struct, like encoding/json does
+var errEmbeddedPtr = errors.New("cannot set embedded pointer to unexported struct")
+
+// fieldByIndex returns the field of 'v' specified by 'index', embedded
+// nil pointers are allocated if 'alloc' is true, otherwise an invalid
+// value is returned for them. An invalid value is also returned if an
+// embedded pointer cannot be allocated, as its type is unexported.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func findField(fields []structField, name string) *structField {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// DefaultMaxBodySize is the default maximal size of request bodies
+const DefaultMaxBodySize = 4 << 20
+
+var errBodyTooLarge = NewError(CodeInvalidArgument, http.StatusRequestEntityTooLarge, "request body too large")
+
+// limitedBody returns errBodyTooLarge if more than 'n' bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	k, e := b.ReadCloser.Read(p)
+	if int64(k) > b.n {
+		k, b.n = int(b.n), -1
+		return k, errBodyTooLarge
+	}
+	b.n -= int64(k)
+	return k, e
+}
+
+// limitBody limits the size of the body of 'r'
+func (s *Server) limitBody(route *route, r *http.Request) {
+	n := route.maxBodySize
+	if n == 0 {
+		n = s.MaxBodySize
+	}
+	if n == 0 {
+		n = DefaultMaxBodySize
+	}
+	if n > 0 && r.Body != nil {
+		r.Body = &limitedBody{ReadCloser: r.Body, n: n}
+	}
+}
+
+// unmarshalJSON is json.Unmarshal which respects DisallowUnknownFields
+func (s *Server) unmarshalJSON(data []byte, v interface{}) error {
+	if !s.DisallowUnknownFields {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
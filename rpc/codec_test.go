@@ -0,0 +1,206 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type codecInner struct {
+	Tags []string `json:"tags"`
+}
+
+type codecArg struct {
+	codecInner
+	Name  string            `json:"name"`
+	Count int               `json:"count"`
+	Ratio float64           `json:"ratio"`
+	On    *bool             `json:"on,omitempty"`
+	Time  time.Time         `json:"time"`
+	Attrs map[string]uint16 `json:"attrs"`
+	Raw   []byte            `json:"raw"`
+	Skip  string            `json:"-"`
+}
+
+func Test_MsgPack(t *testing.T) {
+	on := true
+	in := &codecArg{
+		codecInner: codecInner{Tags: []string{"a", "b"}},
+		Name:       "msgpack",
+		Count:      -300,
+		Ratio:      0.5,
+		On:         &on,
+		Time:       time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Attrs:      map[string]uint16{"x": 65535},
+		Raw:        []byte{1, 2, 3},
+		Skip:       "skip",
+	}
+
+	var buf bytes.Buffer
+	if e := MsgPackCodec.Encode(&buf, in); e != nil {
+		t.Fatal(e)
+	}
+
+	out := &codecArg{}
+	if e := MsgPackCodec.Decode(&buf, out); e != nil {
+		t.Fatal(e)
+	}
+	in.Skip = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("expect %+v, got %+v", in, out)
+	}
+}
+
+func Test_MsgPackMalformed(t *testing.T) {
+	s := newTestServer("/")
+
+	// deeply nested arrays must not overflow the stack
+	body := append(bytes.Repeat([]byte{0x91}, 1<<20), 0xc0)
+	r := httptest.NewRequest("POST", "/echo", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/msgpack")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "max depth") {
+		t.Errorf("unexpected response %d '%s'", w.Code, w.Body.String())
+	}
+
+	var buf bytes.Buffer
+	MsgPackCodec.Encode(&buf, map[string]interface{}{"attrs": map[string]int{"x": 70000}})
+	if e := MsgPackCodec.Decode(&buf, &codecArg{}); e == nil || !strings.Contains(e.Error(), "overflows") {
+		t.Errorf("unexpected error: %v", e)
+	}
+	buf.Reset()
+	MsgPackCodec.Encode(&buf, map[string]interface{}{"count": -1})
+	var u struct {
+		Count uint `json:"count"`
+	}
+	if e := MsgPackCodec.Decode(&buf, &u); e == nil {
+		t.Error("negative value should not be decoded to an unsigned integer")
+	}
+}
+
+func Test_QueryArg(t *testing.T) {
+	s := NewServer("/")
+	s.Add("GET", "get", func(r *http.Request, arg *codecArg) (*codecArg, error) {
+		return arg, nil
+	})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/get?name=q&count=3&tags=a&tags=b&on=true", nil))
+
+	var res struct {
+		Data codecArg `json:"data"`
+	}
+	if e := JSONCodec.Decode(w.Body, &res); e != nil {
+		t.Fatal(e)
+	}
+	arg := &res.Data
+	if arg.Name != "q" || arg.Count != 3 || len(arg.Tags) != 2 || arg.On == nil || !*arg.On {
+		t.Errorf("unexpected argument: %+v", arg)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/get?count=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expect status 400, got %d", w.Code)
+	}
+}
+
+func Test_EmbeddedPointer(t *testing.T) {
+	var arg struct {
+		*codecInner
+		Name string `json:"name"`
+	}
+
+	// the embedded pointer cannot be allocated, as its type is unexported
+	e := DecodeValues(url.Values{"tags": {"a"}, "name": {"x"}}, &arg)
+	if e == nil || !strings.Contains(e.Error(), "embedded pointer") {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	var buf bytes.Buffer
+	MsgPackCodec.Encode(&buf, map[string]interface{}{"tags": []string{"a"}})
+	if e := MsgPackCodec.Decode(&buf, &arg); e == nil || !strings.Contains(e.Error(), "embedded pointer") {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	if e := DecodeValues(url.Values{"name": {"x"}}, &arg); e != nil || arg.Name != "x" {
+		t.Errorf("unexpected result %+v, %v", arg, e)
+	}
+}
+
+func Test_Negotiation(t *testing.T) {
+	s := newTestServer("/")
+
+	for _, c := range []struct {
+		contentType, accept string
+		status              int
+		respType            string
+	}{
+		{"", "", http.StatusOK, "application/json"},
+		{"application/msgpack", "", http.StatusOK, "application/msgpack"},
+		{"", "application/msgpack", http.StatusOK, "application/msgpack"},
+		{"application/msgpack", "application/json;q=0.9, */*;q=0.1", http.StatusOK, "application/json"},
+		{"application/x-www-form-urlencoded", "", http.StatusOK, "application/json"},
+		{"text/xml", "", http.StatusUnsupportedMediaType, "application/json"},
+	} {
+		var body bytes.Buffer
+		switch c.contentType {
+		case "application/msgpack":
+			MsgPackCodec.Encode(&body, &echoArg{Text: "hi"})
+		case "application/x-www-form-urlencoded":
+			body.WriteString("text=hi")
+		default:
+			body.WriteString(`{"text":"hi"}`)
+		}
+
+		r := httptest.NewRequest("POST", "/echo", &body)
+		if len(c.contentType) > 0 {
+			r.Header.Set("Content-Type", c.contentType)
+		}
+		if len(c.accept) > 0 {
+			r.Header.Set("Accept", c.accept)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: expect status %d, got %d", c.contentType, c.status, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, c.respType) {
+			t.Errorf("%s: expect response type '%s', got '%s'", c.contentType, c.respType, ct)
+		}
+	}
+}
+
+func Test_ClientCodec(t *testing.T) {
+	s := NewServer("/")
+	s.Add("POST", "echo", func(r *http.Request, arg *codecArg) (*codecArg, error) {
+		return arg, nil
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	c.Codec = MsgPackCodec
+
+	in := &codecArg{Name: "client", Count: 7, Attrs: map[string]uint16{"y": 1}}
+	out := &codecArg{}
+	if e := c.Call(context.Background(), "echo", in, out); e != nil {
+		t.Fatal(e)
+	}
+	if out.Name != in.Name || out.Count != in.Count || out.Attrs["y"] != 1 {
+		t.Errorf("expect %+v, got %+v", in, out)
+	}
+
+	e := c.Call(context.Background(), "missing", in, out)
+	if e == nil {
+		t.Error("expect an error")
+	}
+}
@@ -0,0 +1,54 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// TimeoutHeader is the HTTP header which carries the remaining time of the
+// deadline of a call, in milliseconds
+const TimeoutHeader = "X-Rpc-Timeout"
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	requestType = reflect.TypeOf((*http.Request)(nil))
+)
+
+type requestKey struct{}
+
+// RequestFromContext returns the HTTP request of a call, it is designed to
+// be used by handlers which accept a context.Context instead of an
+// *http.Request
+func RequestFromContext(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestKey{}).(*http.Request)
+	return r
+}
+
+// withDeadline returns a copy of 'r' whose context carries the request,
+// and the deadline in TimeoutHeader if there is one. The context is also
+// cancelled when the client disconnects, as the server cancels the
+// context of the request
+func withDeadline(r *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if v := r.Header.Get(TimeoutHeader); len(v) > 0 {
+		if ms, e := strconv.ParseInt(v, 10, 64); e == nil && ms > 0 {
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		}
+	}
+	ctx = context.WithValue(ctx, requestKey{}, r)
+	return r.WithContext(ctx), cancel
+}
+
+// setTimeoutHeader propagates the deadline of 'ctx' to the server
+func setTimeoutHeader(ctx context.Context, h http.Header) {
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		h.Set(TimeoutHeader, strconv.FormatInt(ms, 10))
+	}
+}
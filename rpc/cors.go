@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS configures the cross-origin resource sharing of a server
+type CORS struct {
+	AllowedOrigins   []string      // allowed origins, '*' allows all
+	AllowedHeaders   []string      // allowed request headers, empty allows the requested ones
+	ExposedHeaders   []string      // default is TraceHeader and 'Retry-After'
+	AllowCredentials bool          // allow cookies and the 'Authorization' header
+	MaxAge           time.Duration // how long the result of a preflight can be cached
+}
+
+func (c *CORS) allowOrigin(origin string) (allowed, wildcard bool) {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true, true
+		}
+		if strings.EqualFold(o, origin) {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// handle sets the CORS headers of the response, it returns true if the
+// request is a preflight, and the response has been written. 'route' is
+// nil if the route is not found, only the origin headers are set for it,
+// so that the browser can read the error.
+func (c *CORS) handle(w http.ResponseWriter, r *http.Request, route *route) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return false
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+
+	allowed, wildcard := c.allowOrigin(origin)
+	if !allowed {
+		return false
+	}
+
+	if wildcard && !c.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if route == nil || r.Method != "OPTIONS" || len(r.Header.Get("Access-Control-Request-Method")) == 0 {
+		exposed := c.ExposedHeaders
+		if exposed == nil {
+			exposed = []string{TraceHeader, "Retry-After"}
+		}
+		if len(exposed) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+		}
+		return false
+	}
+
+	h.Set("Access-Control-Allow-Methods", route.allow())
+	if len(c.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	} else if v := r.Header.Get("Access-Control-Request-Headers"); len(v) > 0 {
+		h.Set("Access-Control-Allow-Headers", v)
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.FormatInt(int64(c.MaxAge/time.Second), 10))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
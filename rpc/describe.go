@@ -0,0 +1,272 @@
+package rpc
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TypeDesc describes the JSON shape of a Go type.
+// 'Kind' is one of:
+//
+//	bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32,
+//	uint64, float32, float64, string, bytes, time, any, array, map,
+//	struct, ref
+//
+// a 'ref' refers to a named struct type in APIDesc.Types, a 'struct' is an
+// anonymous struct type, the key of a map is always a string.
+type TypeDesc struct {
+	Kind     string      `json:"kind"`
+	Name     string      `json:"name,omitempty"`     // type name of a 'ref'
+	Elem     *TypeDesc   `json:"elem,omitempty"`     // element type of an 'array' or 'map'
+	Fields   []FieldDesc `json:"fields,omitempty"`   // fields of a 'struct'
+	Nullable bool        `json:"nullable,omitempty"` // the value can be null
+	String   bool        `json:"string,omitempty"`   // a number or bool encoded as a JSON string
+}
+
+// FieldDesc describes a field of a struct
+type FieldDesc struct {
+	Name     string    `json:"name"`   // JSON name of the field
+	GoName   string    `json:"goName"` // Go name of the field
+	Type     *TypeDesc `json:"type"`
+	Optional bool      `json:"optional,omitempty"` // the field has the 'omitempty' option
+}
+
+// RouteDesc describes a route, 'Arg' is nil if the handler has no
+// argument, 'Result' is nil if the handler only returns an error, which
+// is always the case for streaming routes
+type RouteDesc struct {
+	Name        string    `json:"name"`
+	Method      string    `json:"method,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Arg         *TypeDesc `json:"arg,omitempty"`
+	Result      *TypeDesc `json:"result,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Deprecated  bool      `json:"deprecated,omitempty"`
+}
+
+// APIDesc describes all registered routes and the named types they use
+type APIDesc struct {
+	Routes []RouteDesc          `json:"routes"`
+	Types  map[string]*TypeDesc `json:"types"`
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+type describer struct {
+	desc  *APIDesc
+	names map[reflect.Type]string
+}
+
+// typeName returns an unique name for a named struct type, the package
+// name is prepended if there's a conflict
+func (d *describer) typeName(t reflect.Type) string {
+	if name, ok := d.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if i := strings.IndexByte(name, '['); i > 0 {
+		name = name[:i]
+	}
+	if _, ok := d.desc.Types[name]; ok {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	for i, base := 2, name; ; i++ {
+		if _, ok := d.desc.Types[name]; !ok {
+			break
+		}
+		name = base + strconv.Itoa(i)
+	}
+
+	d.names[t] = name
+	return name
+}
+
+func (d *describer) describe(t reflect.Type) *TypeDesc {
+	if t == nil {
+		return &TypeDesc{Kind: "any", Nullable: true}
+	}
+
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+
+	var td *TypeDesc
+	switch {
+	case t == timeType:
+		td = &TypeDesc{Kind: "time"}
+	case t.Implements(jsonMarshalerType), reflect.PtrTo(t).Implements(jsonMarshalerType):
+		td = &TypeDesc{Kind: "any"}
+		nullable = true
+	case t.Implements(textMarshalerType), reflect.PtrTo(t).Implements(textMarshalerType):
+		td = &TypeDesc{Kind: "string"}
+	default:
+		td = d.describeKind(t)
+	}
+
+	td.Nullable = td.Nullable || nullable
+	return td
+}
+
+func (d *describer) describeKind(t reflect.Type) *TypeDesc {
+	switch k := t.Kind(); k {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &TypeDesc{Kind: k.String()}
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &TypeDesc{Kind: "bytes", Nullable: true}
+		}
+		return &TypeDesc{Kind: "array", Elem: d.describe(t.Elem()), Nullable: true}
+
+	case reflect.Array:
+		return &TypeDesc{Kind: "array", Elem: d.describe(t.Elem())}
+
+	case reflect.Map:
+		return &TypeDesc{Kind: "map", Elem: d.describe(t.Elem()), Nullable: true}
+
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return &TypeDesc{Kind: "struct", Fields: d.fields(t, nil)}
+		}
+		name := d.typeName(t)
+		if _, ok := d.desc.Types[name]; !ok {
+			// register it before describing fields for recursive types
+			td := &TypeDesc{Kind: "struct"}
+			d.desc.Types[name] = td
+			td.Fields = d.fields(t, nil)
+		}
+		return &TypeDesc{Kind: "ref", Name: name}
+	}
+
+	return &TypeDesc{Kind: "any", Nullable: true}
+}
+
+// fields returns the JSON fields of a struct, fields of embedded structs
+// are promoted like encoding/json does
+func (d *describer) fields(t reflect.Type, fields []FieldDesc) []FieldDesc {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = d.fields(ft, fields)
+				continue
+			}
+		}
+
+		if len(f.PkgPath) > 0 {
+			continue // unexported
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+
+		fd := FieldDesc{
+			Name:     name,
+			GoName:   f.Name,
+			Type:     d.describe(f.Type),
+			Optional: strings.Contains(opts, ",omitempty"),
+		}
+		if strings.Contains(opts, ",string") {
+			switch fd.Type.Kind {
+			case "array", "map", "struct", "ref", "any", "bytes", "time":
+			default:
+				fd.Type.String = true
+			}
+		}
+		fields = append(fields, fd)
+	}
+
+	return fields
+}
+
+// Describe returns the description of all routes of the server
+func (s *Server) Describe() *APIDesc {
+	d := describer{
+		desc:  &APIDesc{Types: make(map[string]*TypeDesc)},
+		names: make(map[reflect.Type]string),
+	}
+
+	names := make([]string, 0, len(s.routes))
+	for name := range s.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		r := s.routes[name]
+		rd := RouteDesc{
+			Name:        name,
+			Method:      r.method,
+			Summary:     r.summary,
+			Description: r.description,
+			Tags:        r.tags,
+			Stream:      r.stream,
+			Version:     r.version,
+			Deprecated:  r.deprecation != nil,
+		}
+		if r.argType != nil {
+			rd.Arg = d.describe(r.argType.Elem())
+		}
+		if r.resType != nil {
+			rd.Result = d.describe(r.resType)
+			// see newResult, int64 and uint64 results are sent as strings
+			if k := rd.Result.Kind; k == "int64" || k == "uint64" {
+				rd.Result.String = true
+			}
+		}
+		d.desc.Routes = append(d.desc.Routes, rd)
+	}
+
+	return d.desc
+}
+
+// ServeDescribe writes the description of all routes of the server in
+// JSON, it is designed to be used as the handler of a describe endpoint
+func (s *Server) ServeDescribe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", contentType)
+	json.NewEncoder(w).Encode(s.Describe())
+}
+
+// Describe returns the description of all routes of DefaultServer
+func Describe() *APIDesc {
+	return DefaultServer.Describe()
+}
+
+// ServeDescribe writes the description of all routes of DefaultServer
+func ServeDescribe(w http.ResponseWriter, r *http.Request) {
+	DefaultServer.ServeDescribe(w, r)
+}
@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type describeUser struct {
+	ID      int64         `json:"id,string"`
+	Name    string        `json:"name,omitempty"`
+	Manager *describeUser `json:"manager"`
+}
+
+func Test_Describe(t *testing.T) {
+	s := NewServer("/api/")
+	s.Add("GET", "user/get", func(r *http.Request, arg *IDArg) (*describeUser, error) {
+		return nil, nil
+	}, WithDoc("get a user", "returns the user with the specified id"), WithTags("user"))
+
+	desc := s.Describe()
+	if len(desc.Routes) != 1 {
+		t.Fatalf("expect 1 route, got %d", len(desc.Routes))
+	}
+	rd := desc.Routes[0]
+	if rd.Summary != "get a user" || rd.Method != "GET" || len(rd.Tags) != 1 {
+		t.Errorf("unexpected route description: %+v", rd)
+	}
+	if rd.Result.Kind != "ref" || rd.Result.Name != "describeUser" || !rd.Result.Nullable {
+		t.Errorf("unexpected result description: %+v", rd.Result)
+	}
+	if td := desc.Types["describeUser"]; td == nil || len(td.Fields) != 3 || !td.Fields[0].Type.String {
+		t.Errorf("unexpected type description: %+v", td)
+	}
+
+	w := httptest.NewRecorder()
+	s.OpenAPIHandler("test", "1.0").ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Summary    string `json:"summary"`
+			Parameters []struct {
+				Name     string `json:"name"`
+				In       string `json:"in"`
+				Required bool   `json:"required"`
+			} `json:"parameters"`
+			RequestBody interface{} `json:"requestBody"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required []string `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if e := json.NewDecoder(w.Body).Decode(&doc); e != nil {
+		t.Fatal(e)
+	}
+	if doc.Paths["/user/get"]["get"].Summary != "get a user" {
+		t.Errorf("unexpected paths: %+v", doc.Paths)
+	}
+	if op := doc.Paths["/user/get"]["get"]; op.RequestBody != nil || len(op.Parameters) != 1 ||
+		op.Parameters[0].Name != "id" || op.Parameters[0].In != "query" || !op.Parameters[0].Required {
+		t.Errorf("unexpected GET operation: %+v", op)
+	}
+	if req := doc.Components.Schemas["describeUser"].Required; len(req) != 2 {
+		t.Errorf("unexpected required fields: %v", req)
+	}
+}
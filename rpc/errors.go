@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Stable error codes used by this package, applications can define their
+// own codes
+const (
+	CodeInvalidArgument   = "invalid_argument"
+	CodeUnauthenticated   = "unauthenticated"
+	CodePermissionDenied  = "permission_denied"
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeConflict          = "conflict"
+	CodeResourceExhausted = "resource_exhausted"
+	CodeUnavailable       = "unavailable"
+	CodeDeadlineExceeded  = "deadline_exceeded"
+	CodeCanceled          = "canceled"
+	CodeInternal          = "internal"
+)
+
+// Error is an error with a stable code, a handler can return it to set the
+// 'code' and the HTTP status of the result.
+// On the client side, Call returns an *Error for a failed result, its
+// 'Status' is the HTTP status of the response, and its 'Details' is the
+// json.RawMessage of the result data, if any
+type Error struct {
+	Code    string      // stable code of the error, e.g. 'not_found'
+	Status  int         // HTTP status of the response, 0 means 200
+	Message string      // human readable message
+	Details interface{} // optional details, sent as the data of the result
+}
+
+// NewError creates a new error
+func NewError(code string, status int, message string, details ...interface{}) *Error {
+	e := &Error{Code: code, Status: status, Message: message}
+	if l := len(details); l > 1 {
+		e.Details = details
+	} else if l == 1 {
+		e.Details = details[0]
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	if len(e.Code) == 0 {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// toError converts 'e' to an *Error, plain errors do not have a code
+func toError(e error) *Error {
+	var re *Error
+	if errors.As(e, &re) {
+		return re
+	}
+
+	var ve ValidationError
+	if errors.As(e, &ve) {
+		return &Error{
+			Code:    CodeInvalidArgument,
+			Status:  http.StatusBadRequest,
+			Message: ve.Error(),
+			Details: ve,
+		}
+	}
+
+	if errors.Is(e, context.DeadlineExceeded) {
+		return &Error{
+			Code:    CodeDeadlineExceeded,
+			Status:  http.StatusGatewayTimeout,
+			Message: e.Error(),
+		}
+	}
+	if errors.Is(e, context.Canceled) {
+		return &Error{Code: CodeCanceled, Message: e.Error()}
+	}
+
+	return &Error{Message: e.Error()}
+}
@@ -0,0 +1,220 @@
+package rpc
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// formCodec decodes 'application/x-www-form-urlencoded' bodies, it cannot
+// encode. The argument must be a struct, its fields are matched with the
+// JSON names, a slice field accepts multiple values of the same name.
+type formCodec struct{}
+
+func (formCodec) ContentType() string {
+	return "application/x-www-form-urlencoded"
+}
+
+func (formCodec) Encode(w io.Writer, v interface{}) error {
+	return ErrNotEncodable
+}
+
+func (formCodec) Decode(r io.Reader, v interface{}) error {
+	data, e := ioutil.ReadAll(r)
+	if e != nil {
+		return e
+	}
+	values, e := url.ParseQuery(string(data))
+	if e != nil {
+		return e
+	}
+	return DecodeValues(values, v)
+}
+
+// DecodeValues decodes URL values, like a query string, into 'v', which
+// must be a pointer to a struct
+func DecodeValues(values url.Values, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("form: argument must be a pointer to a struct")
+	}
+	rv = rv.Elem()
+
+	fields := structFields(rv.Type())
+	for name, vs := range values {
+		f := findField(fields, name)
+		if f == nil || len(vs) == 0 {
+			continue
+		}
+		fv := fieldByIndex(rv, f.index, true)
+		if !fv.IsValid() {
+			return fmt.Errorf("field '%s': %v", name, errEmbeddedPtr)
+		}
+		if e := setFormValue(fv, vs); e != nil {
+			return fmt.Errorf("field '%s': %v", name, e)
+		}
+	}
+
+	return nil
+}
+
+func setFormValue(v reflect.Value, vs []string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		s := reflect.MakeSlice(v.Type(), len(vs), len(vs))
+		for i := range vs {
+			if e := setFormValue(s.Index(i), vs[i:i+1]); e != nil {
+				return e
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+
+	str := vs[len(vs)-1]
+	if v.Type() == timeType {
+		t, e := time.Parse(time.RFC3339Nano, str)
+		if e == nil {
+			v.Set(reflect.ValueOf(t))
+		}
+		return e
+	}
+	if tu, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText([]byte(str))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Slice:
+		v.SetBytes([]byte(str))
+	case reflect.Bool:
+		b, e := strconv.ParseBool(str)
+		if e != nil {
+			return e
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, e := strconv.ParseInt(str, 10, v.Type().Bits())
+		if e != nil {
+			return e
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, e := strconv.ParseUint(str, 10, v.Type().Bits())
+		if e != nil {
+			return e
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, e := strconv.ParseFloat(str, v.Type().Bits())
+		if e != nil {
+			return e
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type '%v'", v.Type())
+	}
+
+	return nil
+}
+
+// EncodeValues encodes 'v', which must be a struct or a pointer to a
+// struct, into URL values, with the same rules as DecodeValues. Nil
+// pointers, maps and interfaces, and empty fields with 'omitempty' are
+// omitted.
+func EncodeValues(v interface{}) (url.Values, error) {
+	values := make(url.Values)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return values, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("form: argument must be a struct or a pointer to a struct")
+	}
+
+	fields := structFields(rv.Type())
+	for i := range fields {
+		f := &fields[i]
+		fv := fieldByIndex(rv, f.index, false)
+		if !fv.IsValid() || (f.omitEmpty && isEmpty(fv)) {
+			continue
+		}
+		if e := addFormValue(values, f.name, fv); e != nil {
+			return nil, fmt.Errorf("field '%s': %v", f.name, e)
+		}
+	}
+
+	return values, nil
+}
+
+func addFormValue(values url.Values, name string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Map, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < v.Len(); i++ {
+			if e := addFormValue(values, name, v.Index(i)); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+
+	if v.Type() == timeType {
+		values.Add(name, v.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	}
+	if tm, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, e := tm.MarshalText()
+		if e != nil {
+			return e
+		}
+		values.Add(name, string(text))
+		return nil
+	}
+
+	var str string
+	switch v.Kind() {
+	case reflect.String:
+		str = v.String()
+	case reflect.Slice:
+		str = string(v.Bytes())
+	case reflect.Bool:
+		str = strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		str = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		str = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		str = strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	default:
+		return fmt.Errorf("unsupported type '%v'", v.Type())
+	}
+	values.Add(name, str)
+	return nil
+}
@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+)
+
+// HandleOn registers a typed handler to 's', the prototype of the handler
+// is checked at compile time, and it is called without reflection. The
+// route accepts any HTTP method unless WithMethod is in 'opts'. A handler
+// without an argument can use struct{} as 'Arg', the route has no argument
+// then, and the request body is not decoded.
+func HandleOn[Arg, Res any](s *Server, name string, fn func(ctx context.Context, arg *Arg) (Res, error), opts ...RouteOption) {
+	r := &route{
+		argType: reflect.TypeOf((*Arg)(nil)),
+		resType: reflect.TypeOf((*Res)(nil)).Elem(),
+		newArg: func() interface{} {
+			return new(Arg)
+		},
+		invoke: func(ci *CallInfo) (interface{}, error) {
+			return fn(ci.Request.Context(), ci.Arg.(*Arg))
+		},
+	}
+	if at := r.argType.Elem(); at.Kind() == reflect.Struct && at.NumField() == 0 {
+		r.argType, r.newArg = nil, nil
+		r.invoke = func(ci *CallInfo) (interface{}, error) {
+			return fn(ci.Request.Context(), new(Arg))
+		}
+	}
+	s.addRoute(name, r, opts)
+}
+
+// Handle registers a typed handler to DefaultServer, see HandleOn
+func Handle[Arg, Res any](name string, fn func(ctx context.Context, arg *Arg) (Res, error), opts ...RouteOption) {
+	HandleOn(DefaultServer, name, fn, opts...)
+}
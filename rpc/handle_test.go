@@ -0,0 +1,115 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func typedEcho(ctx context.Context, arg *echoArg) (string, error) {
+	if len(arg.Text) == 0 {
+		return "", errors.New("text is empty")
+	}
+	return arg.Text, nil
+}
+
+func Test_Handle(t *testing.T) {
+	s := NewServer("/")
+	HandleOn(s, "echo", typedEcho, WithMethod("POST"))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	var res string
+	if e := c.Call(context.Background(), "echo", &echoArg{Text: "typed"}, &res); e != nil || res != "typed" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+	if e := c.Call(context.Background(), "echo", &echoArg{}, &res); e == nil || e.Error() != "text is empty" {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/echo", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect status 405, got %d", w.Code)
+	}
+
+	rd := s.Describe().Routes[0]
+	if rd.Arg == nil || rd.Result == nil || rd.Result.Kind != "string" || rd.Method != "POST" {
+		t.Errorf("unexpected description: %+v", rd)
+	}
+}
+
+func Test_HandleNoArg(t *testing.T) {
+	s := NewServer("/")
+	HandleOn(s, "noarg", func(ctx context.Context, arg *struct{}) (string, error) {
+		return "ok", nil
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	var res string
+	if e := c.Call(context.Background(), "noarg", nil, &res); e != nil || res != "ok" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+	if rd := s.Describe().Routes[0]; rd.Arg != nil {
+		t.Errorf("unexpected argument description: %+v", rd.Arg)
+	}
+}
+
+func benchmarkServer(b *testing.B, s *Server) {
+	const body = `{"text":"hello"}`
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest("POST", "/echo", strings.NewReader(body))
+		w.Body.Reset()
+		s.ServeHTTP(w, r)
+	}
+}
+
+func Benchmark_Add(b *testing.B) {
+	s := NewServer("/")
+	s.Add("POST", "echo", typedEcho)
+	benchmarkServer(b, s)
+}
+
+func Benchmark_Handle(b *testing.B) {
+	s := NewServer("/")
+	HandleOn(s, "echo", typedEcho, WithMethod("POST"))
+	benchmarkServer(b, s)
+}
+
+func benchmarkInvoke(b *testing.B, r *route) {
+	ci := &CallInfo{
+		Route:   "echo",
+		Request: httptest.NewRequest("POST", "/echo", nil),
+		Arg:     &echoArg{Text: "hello"},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.invoke(ci)
+	}
+}
+
+// Benchmark_AddInvoke and Benchmark_HandleInvoke compare the cost of calling
+// the handler only
+func Benchmark_AddInvoke(b *testing.B) {
+	s := NewServer("/")
+	s.Add("POST", "echo", typedEcho)
+	benchmarkInvoke(b, s.routes["echo"])
+}
+
+func Benchmark_HandleInvoke(b *testing.B) {
+	s := NewServer("/")
+	HandleOn(s, "echo", typedEcho)
+	benchmarkInvoke(b, s.routes["echo"])
+}
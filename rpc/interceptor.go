@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+)
+
+// CallInfo describes a call being served
+type CallInfo struct {
+	Route   string        // name of the route
+	Request *http.Request // the HTTP request
+	Arg     interface{}   // decoded argument, nil if the handler has no argument
+	Stream  *StreamWriter // nil if the route is not a streaming route
+}
+
+// Invoker invokes the handler of a call
+type Invoker func(ci *CallInfo) (interface{}, error)
+
+// Interceptor is called around the handler of every call, it can inspect
+// or modify the call and the result, call 'next' to continue, or return
+// without calling 'next' to short-circuit the call
+type Interceptor func(ci *CallInfo, next Invoker) (interface{}, error)
+
+// Use appends interceptors to the server, the first one is the outermost,
+// it should only be called at initialization time
+func (s *Server) Use(interceptors ...Interceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+// Use appends interceptors to DefaultServer
+func Use(interceptors ...Interceptor) {
+	DefaultServer.Use(interceptors...)
+}
+
+func chainInterceptors(interceptors []Interceptor, final Invoker) Invoker {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, n := interceptors[i], next
+		next = func(ci *CallInfo) (interface{}, error) {
+			return ic(ci, n)
+		}
+	}
+	return next
+}
+
+// ClientCall describes an outgoing call
+type ClientCall struct {
+	Context context.Context
+	Method  string      // HTTP method, the argument of GET and HEAD calls is sent in the query string
+	Route   string      // name of the route, or the full URL if called by Call
+	URL     string      // URL of the route
+	Arg     interface{} // argument of the call
+	Result  interface{} // the result is decoded into it
+	Header  http.Header // additional HTTP headers of the request
+}
+
+// ClientInvoker sends a call to the server
+type ClientInvoker func(c *ClientCall) error
+
+// ClientInterceptor is called around every outgoing call made by a Client,
+// it can add headers, retry by calling 'next' more than once, measure the
+// time of the call, and so on
+type ClientInterceptor func(c *ClientCall, next ClientInvoker) error
+
+// UseClient appends client interceptors to DefaultClient, the first one is
+// the outermost, it should only be called at initialization time
+func UseClient(interceptors ...ClientInterceptor) {
+	DefaultClient.Use(interceptors...)
+}
+
+func chainClientInterceptors(interceptors []ClientInterceptor, final ClientInvoker) ClientInvoker {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, n := interceptors[i], next
+		next = func(c *ClientCall) error {
+			return ic(c, n)
+		}
+	}
+	return next
+}
@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// SignJWT creates a JWT signed with HS256, 'claims' usually includes 'sub'
+// and 'exp'
+func SignJWT(claims map[string]interface{}, secret []byte) (string, error) {
+	payload, e := json.Marshal(claims)
+	if e != nil {
+		return "", e
+	}
+
+	s := b64.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + b64.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(s))
+	return s + "." + b64.EncodeToString(mac.Sum(nil)), nil
+}
+
+// JWTAuth authenticates requests with JWT bearer tokens signed with HS256,
+// in the 'Authorization' header. The 'sub' claim is the ID of the
+// principal, and the 'scope' claim, a space separated string, is the
+// scopes.
+type JWTAuth struct {
+	Secret   []byte
+	Issuer   string        // expected 'iss' claim, empty to skip the check
+	Audience string        // expected 'aud' claim, empty to skip the check
+	Leeway   time.Duration // allowed clock skew of 'exp' and 'nbf'
+}
+
+// Authenticate implements Authenticator
+func (a *JWTAuth) Authenticate(r *http.Request) (*Principal, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, e := a.verify(strings.TrimSpace(auth[7:]))
+	if e != nil {
+		return nil, e
+	}
+
+	p := &Principal{Scheme: "jwt", Claims: claims}
+	p.ID, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		p.Expires = time.Unix(int64(exp), 0)
+	}
+	return p, nil
+}
+
+// verify verifies the signature and the claims of a token
+func (a *JWTAuth) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if data, e := b64.DecodeString(parts[0]); e != nil {
+		return nil, errors.New("malformed token header")
+	} else if json.Unmarshal(data, &header) != nil {
+		return nil, errors.New("malformed token header")
+	}
+	// only HS256 is accepted, especially, 'none' is rejected
+	if header.Alg != "HS256" {
+		return nil, errors.New("unsupported token algorithm '" + header.Alg + "'")
+	}
+
+	sig, e := b64.DecodeString(parts[2])
+	if e != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims map[string]interface{}
+	if data, e := b64.DecodeString(parts[1]); e != nil {
+		return nil, errors.New("malformed token payload")
+	} else if json.Unmarshal(data, &claims) != nil {
+		return nil, errors.New("malformed token payload")
+	}
+
+	now := float64(time.Now().Unix())
+	leeway := math.Ceil(a.Leeway.Seconds())
+	if exp, ok := claims["exp"].(float64); ok && now > exp+leeway {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf-leeway {
+		return nil, errors.New("token is not valid yet")
+	}
+	if len(a.Issuer) > 0 && claims["iss"] != a.Issuer {
+		return nil, errors.New("invalid token issuer")
+	}
+	if len(a.Audience) > 0 && !hasAudience(claims["aud"], a.Audience) {
+		return nil, errors.New("invalid token audience")
+	}
+
+	return claims, nil
+}
+
+// hasAudience checks the 'aud' claim, which is a string or an array
+func hasAudience(aud interface{}, expect string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == expect
+	case []interface{}:
+		for _, x := range v {
+			if x == expect {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the default upper bounds of the latency histogram, in
+// seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type routeMetrics struct {
+	calls    uint64
+	errors   map[string]uint64 // by error code
+	inFlight int64
+	buckets  []uint64 // not cumulative
+	sum      float64
+}
+
+// Metrics records the call counts, error counts, latencies and in-flight
+// calls of every route, it serves them in the Prometheus text format
+type Metrics struct {
+	// upper bounds of the latency histogram, default is DefaultBuckets, it
+	// should be set before the metrics are recorded
+	Buckets []float64
+
+	lock   sync.Mutex
+	routes map[string]*routeMetrics
+}
+
+// NewMetrics creates a new Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{routes: make(map[string]*routeMetrics)}
+}
+
+func (m *Metrics) buckets() []float64 {
+	if len(m.Buckets) > 0 {
+		return m.Buckets
+	}
+	return DefaultBuckets
+}
+
+// route returns the metrics of 'name', 'm.lock' must be held
+func (m *Metrics) route(name string) *routeMetrics {
+	rm := m.routes[name]
+	if rm == nil {
+		rm = &routeMetrics{
+			errors:  make(map[string]uint64),
+			buckets: make([]uint64, len(m.buckets())+1),
+		}
+		m.routes[name] = rm
+	}
+	return rm
+}
+
+// begin records the start of a call
+func (m *Metrics) begin(name string) time.Time {
+	m.lock.Lock()
+	m.route(name).inFlight++
+	m.lock.Unlock()
+	return time.Now()
+}
+
+// end records the end of a call started at 'start'
+func (m *Metrics) end(name string, start time.Time, e error) {
+	d := time.Since(start).Seconds()
+	bounds := m.buckets()
+	i := sort.SearchFloat64s(bounds, d)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	rm := m.route(name)
+	rm.inFlight--
+	rm.calls++
+	rm.sum += d
+	rm.buckets[i]++
+	if e != nil {
+		code := toError(e).Code
+		if len(code) == 0 {
+			code = "unknown"
+		}
+		rm.errors[code]++
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	names := make([]string, 0, len(m.routes))
+	for name := range m.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	label := func(name string) string {
+		return `route="` + labelEscaper.Replace(name) + `"`
+	}
+
+	bw.WriteString("# HELP rpc_calls_total Total number of calls.\n# TYPE rpc_calls_total counter\n")
+	for _, name := range names {
+		bw.WriteString("rpc_calls_total{" + label(name) + "} " + strconv.FormatUint(m.routes[name].calls, 10) + "\n")
+	}
+
+	bw.WriteString("# HELP rpc_errors_total Total number of failed calls by error code.\n# TYPE rpc_errors_total counter\n")
+	for _, name := range names {
+		rm := m.routes[name]
+		codes := make([]string, 0, len(rm.errors))
+		for code := range rm.errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			bw.WriteString("rpc_errors_total{" + label(name) + `,code="` + labelEscaper.Replace(code) + `"} `)
+			bw.WriteString(strconv.FormatUint(rm.errors[code], 10) + "\n")
+		}
+	}
+
+	bw.WriteString("# HELP rpc_in_flight Number of calls in progress.\n# TYPE rpc_in_flight gauge\n")
+	for _, name := range names {
+		bw.WriteString("rpc_in_flight{" + label(name) + "} " + strconv.FormatInt(m.routes[name].inFlight, 10) + "\n")
+	}
+
+	bw.WriteString("# HELP rpc_call_duration_seconds Latency of calls.\n# TYPE rpc_call_duration_seconds histogram\n")
+	bounds := m.buckets()
+	for _, name := range names {
+		rm, l := m.routes[name], label(name)
+		var count uint64
+		for i, n := range rm.buckets {
+			count += n
+			le := "+Inf"
+			if i < len(bounds) {
+				le = formatFloat(bounds[i])
+			}
+			bw.WriteString("rpc_call_duration_seconds_bucket{" + l + `,le="` + le + `"} ` + strconv.FormatUint(count, 10) + "\n")
+		}
+		bw.WriteString("rpc_call_duration_seconds_sum{" + l + "} " + formatFloat(rm.sum) + "\n")
+		bw.WriteString("rpc_call_duration_seconds_count{" + l + "} " + strconv.FormatUint(count, 10) + "\n")
+	}
+}
+
+// ServeMetrics writes the metrics of DefaultServer in the Prometheus text
+// format
+func ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	DefaultServer.Metrics.ServeHTTP(w, r)
+}
@@ -0,0 +1,62 @@
+package rpc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Metrics(t *testing.T) {
+	s := newTestServer("/")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	var res string
+	c.Call(context.Background(), "echo", &echoArg{Text: "a"}, &res)
+	c.Call(context.Background(), "echo", &echoArg{}, &res)
+	c.Call(context.Background(), "echo", "bad argument", &res)
+
+	w := httptest.NewRecorder()
+	s.Metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, line := range []string{
+		`rpc_calls_total{route="echo"} 3`,
+		`rpc_errors_total{route="echo",code="invalid_argument"} 1`,
+		`rpc_errors_total{route="echo",code="unknown"} 1`,
+		`rpc_in_flight{route="echo"} 0`,
+		`rpc_call_duration_seconds_bucket{route="echo",le="+Inf"} 3`,
+		`rpc_call_duration_seconds_count{route="echo"} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing '%s' in:\n%s", line, body)
+		}
+	}
+}
+
+func Test_TraceID(t *testing.T) {
+	s := NewServer("/")
+	s.Add("", "trace", func(ctx context.Context) (string, error) {
+		return TraceIDFromContext(ctx), nil
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL + "/")
+	var res string
+	if e := c.Call(WithTraceID(context.Background(), "abc"), "trace", nil, &res); e != nil || res != "abc" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+
+	if e := c.Call(context.Background(), "trace", nil, &res); e != nil || len(res) != 32 {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/trace", nil))
+	if len(w.Header().Get(TraceHeader)) != 32 {
+		t.Error("trace ID is not set in the response")
+	}
+}
@@ -0,0 +1,670 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// msgpackCodec implements the MessagePack format, structs are encoded as
+// maps with the JSON names of their fields, time.Time and types which
+// implement encoding.TextMarshaler are encoded as strings
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	bw := bufio.NewWriter(w)
+	enc := msgpackEncoder{w: bw}
+	if e := enc.encode(reflect.ValueOf(v)); e != nil {
+		return e
+	}
+	return bw.Flush()
+}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("msgpack: decode into non-pointer or nil")
+	}
+	mr, ok := r.(msgpackReader)
+	if !ok {
+		mr = bufio.NewReader(r)
+	}
+	dec := msgpackDecoder{r: mr}
+	return dec.decode(rv.Elem())
+}
+
+type msgpackEncoder struct {
+	w   *bufio.Writer
+	buf [9]byte
+}
+
+func (enc *msgpackEncoder) writeHead(code byte, n uint64, size int) {
+	enc.buf[0] = code
+	switch size {
+	case 1:
+		enc.buf[1] = byte(n)
+	case 2:
+		binary.BigEndian.PutUint16(enc.buf[1:], uint16(n))
+	case 4:
+		binary.BigEndian.PutUint32(enc.buf[1:], uint32(n))
+	case 8:
+		binary.BigEndian.PutUint64(enc.buf[1:], n)
+	}
+	enc.w.Write(enc.buf[:1+size])
+}
+
+func (enc *msgpackEncoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		enc.encodeUint(uint64(n))
+	case n >= -32:
+		enc.w.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		enc.writeHead(0xd0, uint64(n), 1)
+	case n >= math.MinInt16:
+		enc.writeHead(0xd1, uint64(n), 2)
+	case n >= math.MinInt32:
+		enc.writeHead(0xd2, uint64(n), 4)
+	default:
+		enc.writeHead(0xd3, uint64(n), 8)
+	}
+}
+
+func (enc *msgpackEncoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		enc.w.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		enc.writeHead(0xcc, n, 1)
+	case n <= math.MaxUint16:
+		enc.writeHead(0xcd, n, 2)
+	case n <= math.MaxUint32:
+		enc.writeHead(0xce, n, 4)
+	default:
+		enc.writeHead(0xcf, n, 8)
+	}
+}
+
+func (enc *msgpackEncoder) encodeString(s string) {
+	n := uint64(len(s))
+	switch {
+	case n < 32:
+		enc.w.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		enc.writeHead(0xd9, n, 1)
+	case n <= math.MaxUint16:
+		enc.writeHead(0xda, n, 2)
+	default:
+		enc.writeHead(0xdb, n, 4)
+	}
+	enc.w.WriteString(s)
+}
+
+func (enc *msgpackEncoder) encodeBytes(b []byte) {
+	n := uint64(len(b))
+	switch {
+	case n <= math.MaxUint8:
+		enc.writeHead(0xc4, n, 1)
+	case n <= math.MaxUint16:
+		enc.writeHead(0xc5, n, 2)
+	default:
+		enc.writeHead(0xc6, n, 4)
+	}
+	enc.w.Write(b)
+}
+
+func (enc *msgpackEncoder) encodeArrayHead(n int) {
+	switch {
+	case n < 16:
+		enc.w.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		enc.writeHead(0xdc, uint64(n), 2)
+	default:
+		enc.writeHead(0xdd, uint64(n), 4)
+	}
+}
+
+func (enc *msgpackEncoder) encodeMapHead(n int) {
+	switch {
+	case n < 16:
+		enc.w.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		enc.writeHead(0xde, uint64(n), 2)
+	default:
+		enc.writeHead(0xdf, uint64(n), 4)
+	}
+}
+
+func (enc *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		return enc.w.WriteByte(0xc0)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return enc.w.WriteByte(0xc0)
+		}
+	}
+
+	if v.Type() == timeType {
+		enc.encodeString(v.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	}
+	if v.Kind() != reflect.Interface && v.Type().Implements(textMarshalerType) {
+		text, e := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if e != nil {
+			return e
+		}
+		enc.encodeString(string(text))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return enc.encode(v.Elem())
+
+	case reflect.Bool:
+		if v.Bool() {
+			return enc.w.WriteByte(0xc3)
+		}
+		return enc.w.WriteByte(0xc2)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		enc.encodeInt(v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		enc.encodeUint(v.Uint())
+
+	case reflect.Float32:
+		enc.writeHead(0xca, uint64(math.Float32bits(float32(v.Float()))), 4)
+
+	case reflect.Float64:
+		enc.writeHead(0xcb, math.Float64bits(v.Float()), 8)
+
+	case reflect.String:
+		enc.encodeString(v.String())
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice {
+				if v.IsNil() {
+					return enc.w.WriteByte(0xc0)
+				}
+				enc.encodeBytes(v.Bytes())
+			} else {
+				b := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(b), v)
+				enc.encodeBytes(b)
+			}
+			return nil
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return enc.w.WriteByte(0xc0)
+		}
+		enc.encodeArrayHead(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if e := enc.encode(v.Index(i)); e != nil {
+				return e
+			}
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			return enc.w.WriteByte(0xc0)
+		}
+		enc.encodeMapHead(v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if e := enc.encode(iter.Key()); e != nil {
+				return e
+			}
+			if e := enc.encode(iter.Value()); e != nil {
+				return e
+			}
+		}
+
+	case reflect.Struct:
+		return enc.encodeStruct(v)
+
+	default:
+		return fmt.Errorf("msgpack: unsupported type '%v'", v.Type())
+	}
+
+	return nil
+}
+
+func (enc *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	fields := structFields(v.Type())
+	values := make([]reflect.Value, len(fields))
+
+	n := 0
+	for i := range fields {
+		fv := fieldByIndex(v, fields[i].index, false)
+		if !fv.IsValid() || (fields[i].omitEmpty && isEmpty(fv)) {
+			continue
+		}
+		values[i] = fv
+		n++
+	}
+
+	enc.encodeMapHead(n)
+	for i := range fields {
+		if !values[i].IsValid() {
+			continue
+		}
+		enc.encodeString(fields[i].name)
+		if e := enc.encode(values[i]); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+type msgpackReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type msgpackDecoder struct {
+	r     msgpackReader
+	depth int // nesting depth of arrays and maps
+}
+
+// msgpackMaxDepth limits the nesting depth of arrays and maps, so that a
+// malicious input cannot overflow the stack, it is the same as encoding/json
+const msgpackMaxDepth = 10000
+
+var (
+	errMsgpackType     = errors.New("msgpack: type mismatch")
+	errMsgpackDepth    = errors.New("msgpack: exceeded max depth")
+	errMsgpackOverflow = errors.New("msgpack: value overflows the type")
+)
+
+// enter increases the nesting depth, it fails if the depth is too large
+func (dec *msgpackDecoder) enter() error {
+	if dec.depth++; dec.depth > msgpackMaxDepth {
+		return errMsgpackDepth
+	}
+	return nil
+}
+
+// readN reads 'n' bytes, the buffer grows with the data read, so that a
+// malformed length does not allocate too much memory
+func (dec *msgpackDecoder) readN(n int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, e := io.CopyN(&buf, dec.r, int64(n)); e != nil {
+		if e == io.EOF {
+			e = io.ErrUnexpectedEOF
+		}
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// capacity limits the preallocated capacity of arrays and maps
+func capacity(n int) int {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+func (dec *msgpackDecoder) readUint(size int) (uint64, error) {
+	var b [8]byte
+	if _, e := io.ReadFull(dec.r, b[:size]); e != nil {
+		return 0, e
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b[:])), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b[:])), nil
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+// decodeAny decodes the next value as one of: nil, bool, int64, uint64,
+// float64, string, []byte, []interface{}, map[string]interface{}
+func (dec *msgpackDecoder) decodeAny() (interface{}, error) {
+	code, e := dec.r.ReadByte()
+	if e != nil {
+		return nil, e
+	}
+	return dec.decodeAnyWithCode(code)
+}
+
+func (dec *msgpackDecoder) decodeAnyWithCode(code byte) (interface{}, error) {
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xe0 == 0xa0:
+		return dec.readString(int(code & 0x1f))
+	case code&0xf0 == 0x90:
+		return dec.readArray(int(code & 0x0f))
+	case code&0xf0 == 0x80:
+		return dec.readMap(int(code & 0x0f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return dec.readUint(1 << (code - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		n, e := dec.readUint(size)
+		if e != nil {
+			return nil, e
+		}
+		shift := uint(64 - size*8)
+		return int64(n<<shift) >> shift, nil
+	case 0xca:
+		n, e := dec.readUint(4)
+		return float64(math.Float32frombits(uint32(n))), e
+	case 0xcb:
+		n, e := dec.readUint(8)
+		return math.Float64frombits(n), e
+	case 0xd9, 0xda, 0xdb:
+		n, e := dec.readUint(1 << (code - 0xd9))
+		if e != nil {
+			return nil, e
+		}
+		return dec.readString(int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, e := dec.readUint(1 << (code - 0xc4))
+		if e != nil {
+			return nil, e
+		}
+		return dec.readN(int(n))
+	case 0xdc, 0xdd:
+		n, e := dec.readUint(2 << (code - 0xdc))
+		if e != nil {
+			return nil, e
+		}
+		return dec.readArray(int(n))
+	case 0xde, 0xdf:
+		n, e := dec.readUint(2 << (code - 0xde))
+		if e != nil {
+			return nil, e
+		}
+		return dec.readMap(int(n))
+	}
+
+	return nil, fmt.Errorf("msgpack: unsupported code 0x%02x", code)
+}
+
+func (dec *msgpackDecoder) readString(n int) (string, error) {
+	b, e := dec.readN(n)
+	return string(b), e
+}
+
+func (dec *msgpackDecoder) readArray(n int) ([]interface{}, error) {
+	defer func() { dec.depth-- }()
+	if e := dec.enter(); e != nil {
+		return nil, e
+	}
+
+	a := make([]interface{}, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		v, e := dec.decodeAny()
+		if e != nil {
+			return nil, e
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (dec *msgpackDecoder) readMap(n int) (map[string]interface{}, error) {
+	defer func() { dec.depth-- }()
+	if e := dec.enter(); e != nil {
+		return nil, e
+	}
+
+	m := make(map[string]interface{}, capacity(n))
+	for i := 0; i < n; i++ {
+		k, e := dec.decodeAny()
+		if e != nil {
+			return nil, e
+		}
+		v, e := dec.decodeAny()
+		if e != nil {
+			return nil, e
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
+
+// decode decodes the next value into 'v', the value is first decoded as
+// a generic value, and then assigned to 'v'
+func (dec *msgpackDecoder) decode(v reflect.Value) error {
+	x, e := dec.decodeAny()
+	if e != nil {
+		return e
+	}
+	return assignValue(v, x)
+}
+
+// assignValue assigns a generic value returned by decodeAny to 'v'
+func assignValue(v reflect.Value, x interface{}) error {
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return assignValue(v.Elem(), x)
+	}
+
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		v.Set(reflect.ValueOf(x))
+		return nil
+	}
+
+	if s, ok := x.(string); ok {
+		if v.Type() == timeType {
+			t, e := time.Parse(time.RFC3339Nano, s)
+			if e == nil {
+				v.Set(reflect.ValueOf(t))
+			}
+			return e
+		}
+		if v.CanAddr() {
+			if tu, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+				return tu.UnmarshalText([]byte(s))
+			}
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := x.(bool)
+		if !ok {
+			return errMsgpackType
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch n := x.(type) {
+		case int64:
+			i = n
+		case uint64:
+			if n > math.MaxInt64 {
+				return errMsgpackOverflow
+			}
+			i = int64(n)
+		case float64:
+			if n < math.MinInt64 || n >= math.MaxInt64 {
+				return errMsgpackOverflow
+			}
+			i = int64(n)
+		case string:
+			var e error
+			if i, e = strconv.ParseInt(n, 10, 64); e != nil {
+				return e
+			}
+		default:
+			return errMsgpackType
+		}
+		if v.OverflowInt(i) {
+			return errMsgpackOverflow
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch n := x.(type) {
+		case int64:
+			if n < 0 {
+				return errMsgpackOverflow
+			}
+			u = uint64(n)
+		case uint64:
+			u = n
+		case float64:
+			if n < 0 || n >= math.MaxUint64 {
+				return errMsgpackOverflow
+			}
+			u = uint64(n)
+		case string:
+			var e error
+			if u, e = strconv.ParseUint(n, 10, 64); e != nil {
+				return e
+			}
+		default:
+			return errMsgpackType
+		}
+		if v.OverflowUint(u) {
+			return errMsgpackOverflow
+		}
+		v.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		switch n := x.(type) {
+		case int64:
+			v.SetFloat(float64(n))
+		case uint64:
+			v.SetFloat(float64(n))
+		case float64:
+			v.SetFloat(n)
+		default:
+			return errMsgpackType
+		}
+
+	case reflect.String:
+		switch s := x.(type) {
+		case string:
+			v.SetString(s)
+		case []byte:
+			v.SetString(string(s))
+		default:
+			return errMsgpackType
+		}
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			switch b := x.(type) {
+			case []byte:
+				v.SetBytes(b)
+				return nil
+			case string:
+				v.SetBytes([]byte(b))
+				return nil
+			}
+		}
+		a, ok := x.([]interface{})
+		if !ok {
+			return errMsgpackType
+		}
+		s := reflect.MakeSlice(v.Type(), len(a), len(a))
+		for i := range a {
+			if e := assignValue(s.Index(i), a[i]); e != nil {
+				return e
+			}
+		}
+		v.Set(s)
+
+	case reflect.Array:
+		a, ok := x.([]interface{})
+		if !ok {
+			return errMsgpackType
+		}
+		for i := 0; i < v.Len() && i < len(a); i++ {
+			if e := assignValue(v.Index(i), a[i]); e != nil {
+				return e
+			}
+		}
+
+	case reflect.Map:
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return errMsgpackType
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(m)))
+		}
+		kt, et := v.Type().Key(), v.Type().Elem()
+		for k, item := range m {
+			kv := reflect.New(kt).Elem()
+			if e := assignValue(kv, k); e != nil {
+				return e
+			}
+			ev := reflect.New(et).Elem()
+			if e := assignValue(ev, item); e != nil {
+				return e
+			}
+			v.SetMapIndex(kv, ev)
+		}
+
+	case reflect.Struct:
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return errMsgpackType
+		}
+		fields := structFields(v.Type())
+		for k, item := range m {
+			f := findField(fields, k)
+			if f == nil {
+				continue
+			}
+			fv := fieldByIndex(v, f.index, true)
+			if !fv.IsValid() {
+				return fmt.Errorf("field '%s': %v", k, errEmbeddedPtr)
+			}
+			if e := assignValue(fv, item); e != nil {
+				return fmt.Errorf("field '%s': %v", k, e)
+			}
+		}
+
+	default:
+		return fmt.Errorf("msgpack: unsupported type '%v'", v.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type object = map[string]interface{}
+
+func (td *TypeDesc) schema() object {
+	var s object
+
+	switch td.Kind {
+	case "bool":
+		s = object{"type": "boolean"}
+	case "int8", "int16", "int32", "uint8", "uint16":
+		s = object{"type": "integer", "format": "int32"}
+	case "int", "int64", "uint", "uint32", "uint64":
+		s = object{"type": "integer", "format": "int64"}
+	case "float32":
+		s = object{"type": "number", "format": "float"}
+	case "float64":
+		s = object{"type": "number", "format": "double"}
+	case "string":
+		s = object{"type": "string"}
+	case "bytes":
+		s = object{"type": "string", "format": "byte"}
+	case "time":
+		s = object{"type": "string", "format": "date-time"}
+	case "array":
+		s = object{"type": "array", "items": td.Elem.schema()}
+	case "map":
+		s = object{"type": "object", "additionalProperties": td.Elem.schema()}
+	case "struct":
+		s = fieldsSchema(td.Fields)
+	case "ref":
+		ref := object{"$ref": "#/components/schemas/" + td.Name}
+		if !td.Nullable {
+			return ref
+		}
+		// siblings of '$ref' are ignored, so wrap it with 'allOf'
+		return object{"allOf": []object{ref}, "nullable": true}
+	default:
+		s = object{}
+	}
+
+	if td.String {
+		s = object{"type": "string"}
+	} else if strings.HasPrefix(td.Kind, "uint") {
+		s["minimum"] = 0
+	}
+
+	if td.Nullable {
+		s["nullable"] = true
+	}
+	return s
+}
+
+func fieldsSchema(fields []FieldDesc) object {
+	props := make(object, len(fields))
+	var required []string
+	for _, f := range fields {
+		props[f.Name] = f.Type.schema()
+		if !f.Optional {
+			required = append(required, f.Name)
+		}
+	}
+
+	s := object{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func resultSchema(data *TypeDesc) object {
+	props := object{
+		"succeeded": object{"type": "boolean"},
+		"code":      object{"type": "string"},
+		"message":   object{"type": "string"},
+	}
+	if data != nil {
+		props["data"] = data.schema()
+	}
+	return object{
+		"type":       "object",
+		"properties": props,
+		"required":   []string{"succeeded"},
+	}
+}
+
+// queryParams returns the query parameters of the fields of argument 'arg'
+func (d *APIDesc) queryParams(arg *TypeDesc) []object {
+	fields := arg.Fields
+	if arg.Kind == "ref" {
+		if td := d.Types[arg.Name]; td != nil {
+			fields = td.Fields
+		}
+	}
+
+	params := make([]object, 0, len(fields))
+	for _, f := range fields {
+		params = append(params, object{
+			"name":     f.Name,
+			"in":       "query",
+			"required": !f.Optional,
+			"schema":   f.Type.schema(),
+		})
+	}
+	return params
+}
+
+// OpenAPI converts the description to an OpenAPI 3 document, 'serverURL'
+// is the URL where the routes are mounted, e.g. 'https://example.com/api'.
+// The document can be encoded with encoding/json.
+func (d *APIDesc) OpenAPI(title, version, serverURL string) map[string]interface{} {
+	paths := make(object, len(d.Routes))
+	for i := range d.Routes {
+		r := &d.Routes[i]
+
+		op := object{
+			"operationId": r.Name,
+			"responses": object{
+				"200": object{
+					"description": "the result of the call, check 'succeeded' for errors",
+					"content": object{
+						"application/json": object{"schema": resultSchema(r.Result)},
+					},
+				},
+			},
+		}
+		if r.Stream {
+			op["responses"] = object{
+				"200": object{
+					"description": "a stream of results, the last one is failed or has code '" + CodeStreamEnd + "'",
+					"content": object{
+						ndjsonType: object{"schema": resultSchema(nil)},
+						sseType:    object{"schema": resultSchema(nil)},
+					},
+				},
+			}
+		}
+		if len(r.Summary) > 0 {
+			op["summary"] = r.Summary
+		}
+		if len(r.Description) > 0 {
+			op["description"] = r.Description
+		}
+		if len(r.Tags) > 0 {
+			op["tags"] = r.Tags
+		}
+		if r.Deprecated {
+			op["deprecated"] = true
+		}
+		method := strings.ToLower(r.Method)
+		if len(method) == 0 {
+			method = "post"
+		}
+		switch {
+		case r.Arg == nil:
+		case method == "get" || method == "head":
+			// the argument of GET and HEAD routes is in the query string
+			if params := d.queryParams(r.Arg); len(params) > 0 {
+				op["parameters"] = params
+			}
+		default:
+			op["requestBody"] = object{
+				"required": true,
+				"content": object{
+					"application/json": object{"schema": r.Arg.schema()},
+				},
+			}
+		}
+		paths["/"+r.Name] = object{method: op}
+	}
+
+	schemas := make(object, len(d.Types))
+	for name, td := range d.Types {
+		schemas[name] = fieldsSchema(td.Fields)
+	}
+
+	doc := object{
+		"openapi":    "3.0.3",
+		"info":       object{"title": title, "version": version},
+		"paths":      paths,
+		"components": object{"schemas": schemas},
+	}
+	if len(serverURL) > 0 {
+		doc["servers"] = []object{{"url": serverURL}}
+	}
+	return doc
+}
+
+// OpenAPIHandler returns a handler which writes the OpenAPI 3 document of
+// the server, the URL of the server in the document is 'Prefix'
+func (s *Server) OpenAPIHandler(title, version string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc := s.Describe().OpenAPI(title, version, strings.TrimSuffix(s.Prefix, "/"))
+		w.Header().Add("Content-Type", contentType)
+		json.NewEncoder(w).Encode(doc)
+	})
+}
@@ -0,0 +1,169 @@
+package rpc
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KeyFunc returns the key of the caller of a request, calls with the same
+// key share a token bucket
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP uses the IP address of the remote peer as the key, note that it
+// is the address of the proxy if the server is behind one
+func KeyByIP(r *http.Request) string {
+	host, _, e := net.SplitHostPort(r.RemoteAddr)
+	if e != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader uses the value of header 'name' as the key, e.g. an API key
+// header, callers without the header share one bucket
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// RateLimit limits the rate of calls to a route with a token bucket per
+// caller, a bucket holds at most 'Burst' tokens, and is refilled with
+// 'Rate' tokens per second, every call takes one token. A quota, like
+// 1000 calls per day, can be set with Rate 1000/86400 and Burst 1000.
+type RateLimit struct {
+	Rate  float64 // tokens per second, not greater than 0 means no limit
+	Burst int     // capacity of the bucket, at least 1
+	Key   KeyFunc // key of the caller, default is KeyByIP
+}
+
+// WithRateLimit sets the rate limit of a route, it overrides the default
+// rate limit of the server
+func WithRateLimit(limit RateLimit) RouteOption {
+	return func(r *route) {
+		r.rateLimit = &limit
+	}
+}
+
+// RetryInfo is the details of an error with code CodeResourceExhausted, the
+// 'Retry-After' header of the HTTP response is also set
+type RetryInfo struct {
+	RetryAfter int64 `json:"retry_after"` // milliseconds to wait before retrying
+}
+
+// LimitStore stores the token buckets of rate limits, implement it with a
+// shared storage to limit the rate of a cluster of servers
+type LimitStore interface {
+	// Take takes a token from bucket 'key', it returns false and the time
+	// to wait before a token is available if the bucket is empty
+	Take(key string, rate float64, burst int) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+// refill adds the tokens since the last refill
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// MemoryLimitStore is a LimitStore in memory, full buckets are removed
+// periodically to save memory
+type MemoryLimitStore struct {
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryLimitStore creates a new MemoryLimitStore
+func NewMemoryLimitStore() *MemoryLimitStore {
+	return &MemoryLimitStore{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// Take implements LimitStore
+func (m *MemoryLimitStore) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if now.Sub(m.lastSweep) > time.Minute {
+		m.sweep(now)
+	}
+
+	b := m.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	b.rate, b.burst = rate, float64(burst)
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep removes full buckets, which are the same as new ones
+func (m *MemoryLimitStore) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
+
+// checkRateLimit takes a token for a call to 'route', the call is allowed
+// if the store fails, so that the routes keep working
+func (s *Server) checkRateLimit(name string, route *route, r *http.Request) error {
+	limit := route.rateLimit
+	if limit == nil {
+		limit = s.RateLimit
+	}
+	if limit == nil || limit.Rate <= 0 || s.LimitStore == nil {
+		return nil
+	}
+
+	key := limit.Key
+	if key == nil {
+		key = KeyByIP
+	}
+
+	ok, wait, e := s.LimitStore.Take(name+"\x00"+key(r), limit.Rate, limit.Burst)
+	if e != nil || ok {
+		return nil
+	}
+
+	ms := wait.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return NewError(CodeResourceExhausted, http.StatusTooManyRequests,
+		"rate limit exceeded, retry after "+strconv.FormatInt(ms, 10)+"ms",
+		&RetryInfo{RetryAfter: ms})
+}
+
+// setRetryAfter sets the 'Retry-After' header, in seconds, if the result
+// is a rate limit error
+func setRetryAfter(w http.ResponseWriter, res *Result) {
+	if ri, ok := res.Data.(*RetryInfo); ok && !res.Succeeded {
+		w.Header().Set("Retry-After", strconv.FormatInt((ri.RetryAfter+999)/1000, 10))
+	}
+}
@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_RateLimit(t *testing.T) {
+	s := newTestServer("/")
+	s.Add("", "limited", func(r *http.Request) error { return nil },
+		WithRateLimit(RateLimit{Rate: 0.01, Burst: 2, Key: KeyByHeader("X-Api-Key")}))
+
+	call := func(route, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/"+route, nil)
+		r.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := call("limited", "a"); w.Code != http.StatusOK {
+			t.Fatalf("call %d: unexpected status %d", i, w.Code)
+		}
+	}
+
+	w := call("limited", "a")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "100" {
+		t.Errorf("unexpected status %d, retry after '%s'", w.Code, w.Header().Get("Retry-After"))
+	}
+	var res struct {
+		Code string    `json:"code"`
+		Data RetryInfo `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&res)
+	if res.Code != CodeResourceExhausted || res.Data.RetryAfter < 99000 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	// other callers and routes are not affected
+	if w := call("limited", "b"); w.Code != http.StatusOK {
+		t.Errorf("unexpected status %d", w.Code)
+	}
+	if w := call("ping", "a"); w.Code != http.StatusOK {
+		t.Errorf("unexpected status %d", w.Code)
+	}
+}
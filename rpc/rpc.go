@@ -1,13 +1,15 @@
 package rpc
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // IDArg is the most useful argument type, so define it here
@@ -18,6 +20,7 @@ type IDArg struct {
 // Result is the API result type
 type Result struct {
 	Succeeded bool        `json:"succeeded"`
+	Code      string      `json:"code,omitempty"`
 	Message   string      `json:"message,omitempty"`
 	Data      interface{} `json:"data,omitempty"`
 }
@@ -39,27 +42,111 @@ func NewResult(succeeded bool, message string, data ...interface{}) *Result {
 }
 
 type route struct {
-	method  string
-	handler reflect.Value
+	method         string
+	argType        reflect.Type       // pointer type of the argument, nil if there's no argument
+	resType        reflect.Type       // nil if the handler only returns an error
+	newArg         func() interface{} // allocates an argument, nil if there's no argument
+	invoke         Invoker            // calls the handler
+	summary        string
+	description    string
+	tags           []string
+	stream         bool  // the last parameter of the handler is a *StreamWriter
+	maxBodySize    int64 // 0 means the limit of the server
+	rateLimit      *RateLimit
+	authenticators []Authenticator
+	scopes         []string
+	cacheTTL       time.Duration // 0 means the results are not cached
+	version        string
+	deprecation    *deprecation
 }
 
-func (r *route) newArg() interface{} {
-	t := r.handler.Type()
-	if t.NumIn() < 2 {
-		return nil
+// RouteOption sets an option of a route when it is registered
+type RouteOption func(r *route)
+
+// WithDoc sets the summary and the description of a route, they are
+// included in the output of Describe and OpenAPI
+func WithDoc(summary, description string) RouteOption {
+	return func(r *route) {
+		r.summary, r.description = summary, description
+	}
+}
+
+// WithTags sets the tags of a route, they are used to group routes in the
+// OpenAPI document
+func WithTags(tags ...string) RouteOption {
+	return func(r *route) {
+		r.tags = append(r.tags, tags...)
+	}
+}
+
+// WithMaxBodySize sets the maximal size of the request body of a route, it
+// overrides the limit of the server, a negative value means no limit
+func WithMaxBodySize(size int64) RouteOption {
+	return func(r *route) {
+		r.maxBodySize = size
+	}
+}
+
+// allows reports whether the route accepts HTTP method 'm', GET routes
+// accept HEAD requests
+func (r *route) allows(m string) bool {
+	return len(r.method) == 0 || r.method == m || (m == "HEAD" && r.method == "GET")
+}
+
+// allow returns the value of the 'Allow' header of the route
+func (r *route) allow() string {
+	switch r.method {
+	case "":
+		return "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+	case "GET":
+		return "GET, HEAD, OPTIONS"
+	default:
+		return r.method + ", OPTIONS"
+	}
+}
+
+// WithMethod sets the HTTP method of a route, it is designed for Handle,
+// as Add accepts the method directly
+func WithMethod(method string) RouteOption {
+	return func(r *route) {
+		r.method = method
 	}
-	return reflect.New(t.In(1).Elem()).Interface()
 }
 
-func (r *route) callHandler(req *http.Request, arg interface{}) (interface{}, error) {
-	in := make([]reflect.Value, 0, 2)
-	in = append(in, reflect.ValueOf(req))
-	if r.handler.Type().NumIn() > 1 {
-		in = append(in, reflect.ValueOf(arg))
+// RouteMethod returns the HTTP method of route 'name', it is empty if the
+// route accepts any method, 'ok' is false if the route does not exist
+func (s *Server) RouteMethod(name string) (method string, ok bool) {
+	r, ok := s.routes[name]
+	if !ok {
+		return "", false
 	}
+	return r.method, true
+}
 
-	out := r.handler.Call(in)
+// reflectInvoker returns an Invoker which calls 'handler' with reflection,
+// the prototype of 'handler' must have been checked by Add
+func reflectInvoker(handler reflect.Value, r *route) Invoker {
+	withContext := handler.Type().In(0) == contextType
+	return func(ci *CallInfo) (interface{}, error) {
+		in := make([]reflect.Value, 0, 3)
+		if withContext {
+			in = append(in, reflect.ValueOf(ci.Request.Context()))
+		} else {
+			in = append(in, reflect.ValueOf(ci.Request))
+		}
+		if r.argType != nil {
+			in = append(in, reflect.ValueOf(ci.Arg))
+		}
+		if r.stream {
+			in = append(in, reflect.ValueOf(ci.Stream))
+		}
+		return handlerResult(handler.Call(in))
+	}
+}
 
+// handlerResult converts the return values of a handler called with
+// reflection
+func handlerResult(out []reflect.Value) (interface{}, error) {
 	var res, e interface{}
 	if len(out) == 1 {
 		e = out[0].Interface()
@@ -76,35 +163,103 @@ func (r *route) callHandler(req *http.Request, arg interface{}) (interface{}, er
 
 const contentType = "application/json; charset=utf-8"
 
-var routes = make(map[string]route, 64)
+// Server dispatches HTTP requests to the handlers in its route table, its
+// ServeHTTP method strips 'Prefix' from the URL path to get the route name
+type Server struct {
+	Prefix    string  // URL prefix where the server is mounted, e.g. '/api/'
+	Codecs    []Codec // supported codecs, nil means DefaultCodecs
+	WebSocket WebSocketOptions
+	CORS      *CORS // nil disables cross-origin requests
+
+	// maximal size of request bodies, 0 means DefaultMaxBodySize, and a
+	// negative value means no limit, see also WithMaxBodySize
+	MaxBodySize int64
+	// reject JSON arguments with fields which are not in the argument type
+	DisallowUnknownFields bool
+	// logs warnings, like calls to deprecated routes, default is log.Printf
+	Logf func(format string, v ...interface{})
+
+	RateLimit     *RateLimit    // default rate limit of the routes, nil means no limit
+	Metrics       *Metrics      // metrics of the routes, nil disables them
+	LimitStore    LimitStore    // storage of the rate limits, default is in memory
+	Idempotency   *Idempotency  // handling of IdempotencyHeader, nil disables it
+	ResponseStore ResponseStore // storage of idempotent and cached results, default is in memory
+	routes        map[string]*route
+	interceptors  []Interceptor
+	wsLock        sync.Mutex
+	wsConns       map[*WSConn]struct{}
+}
+
+// NewServer creates a new server mounted at 'prefix'
+func NewServer(prefix string) *Server {
+	return &Server{
+		Prefix:        prefix,
+		LimitStore:    NewMemoryLimitStore(),
+		Metrics:       NewMetrics(),
+		ResponseStore: NewMemoryResponseStore(),
+		routes:        make(map[string]*route, 64),
+	}
+}
 
 // Add register a API handler to route map.
 // the function should only be called at initialization time,
 // otherwise there can be a race condition
 // 'handler' should be a function with proto type
-//     func(r *http.Request, args *TypeXXX) (interface{}, error)
+//
+//	func(r *http.Request, args *TypeXXX) (interface{}, error)
+//
 // or
-//     func(r *http.Request) (interface{}, error)
+//
+//	func(r *http.Request) (interface{}, error)
+//
 // or
-//     func(r *http.Request, args *TypeXXX) error
+//
+//	func(r *http.Request, args *TypeXXX) error
+//
 // or
-//     func(r *http.Request) error
-func Add(method, name string, handler interface{}) {
-	if _, ok := routes[name]; ok {
-		panic(fmt.Errorf("route '%v' already registered", name))
-	}
-
+//
+//	func(r *http.Request) error
+//
+// the first argument can also be a context.Context, which is cancelled when
+// the deadline propagated by CallContext passes or the client disconnects,
+// use RequestFromContext to get the HTTP request
+//
+//	func(ctx context.Context, args *TypeXXX) (interface{}, error)
+//
+// the argument is decoded with the codec matching the Content-Type of the
+// request, but for GET and HEAD requests, it is decoded from the query
+// string, see DecodeValues.
+// a streaming handler receives a *StreamWriter as its last argument, and
+// only returns an error, see StreamWriter for details
+//
+//	func(ctx context.Context, args *TypeXXX, stream *StreamWriter) error
+//
+// 'opts' are the options of the route, like WithDoc.
+// see also Handle, which registers a typed handler without reflection
+func (s *Server) Add(method, name string, handler interface{}, opts ...RouteOption) {
 	t := reflect.TypeOf(handler)
 	if t.Kind() != reflect.Func {
 		panic(fmt.Errorf("handler of route '%v' is not a function", name))
 	}
 
 	e := fmt.Errorf("handler proto type of route '%v' is wrong", name)
-	if num := t.NumIn(); num < 1 || num > 2 {
+	num := t.NumIn()
+	if num < 1 || num > 3 {
 		panic(e)
 	}
 
-	if t.In(0) != reflect.TypeOf((*http.Request)(nil)) {
+	stream := t.In(num-1) == streamWriterType
+	if stream {
+		num--
+	}
+	if num > 2 || stream && t.NumOut() != 1 {
+		panic(e)
+	}
+	if num == 2 && t.In(1).Kind() != reflect.Ptr {
+		panic(e)
+	}
+
+	if in := t.In(0); in != requestType && in != contextType {
 		panic(e)
 	}
 
@@ -118,74 +273,264 @@ func Add(method, name string, handler interface{}) {
 		panic(e)
 	}
 
-	routes[name] = route{method: method, handler: reflect.ValueOf(handler)}
+	r := &route{method: method, stream: stream}
+	if num == 2 {
+		at := t.In(1)
+		r.argType = at
+		r.newArg = func() interface{} {
+			return reflect.New(at.Elem()).Interface()
+		}
+	}
+	if t.NumOut() == 2 {
+		r.resType = t.Out(0)
+	}
+	r.invoke = reflectInvoker(reflect.ValueOf(handler), r)
+
+	s.addRoute(name, r, opts)
+}
+
+// addRoute applies 'opts' to 'r', and adds it to the route table
+func (s *Server) addRoute(name string, r *route, opts []RouteOption) {
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	name = routeKey(r.version, name)
+	if _, ok := s.routes[name]; ok {
+		panic(fmt.Errorf("route '%v' already registered", name))
+	}
+
+	if r.argType != nil {
+		if e := checkRules(r.argType, map[reflect.Type]bool{}); e != nil {
+			panic(fmt.Errorf("validation rules of route '%v': %v", name, e))
+		}
+	}
+
+	s.routes[name] = r
 }
 
 // ServeHTTP handles HTTP request
-func ServeHTTP(urlPrefix string, w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Path[len(urlPrefix):]
-	route, ok := routes[name]
-	if !ok {
-		w.WriteHeader(http.StatusFound)
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serve(s.Prefix, w, r)
+}
+
+func (s *Server) serve(urlPrefix string, w http.ResponseWriter, r *http.Request) {
+	var name string
+	var route *route
+	if strings.HasPrefix(r.URL.Path, urlPrefix) {
+		name, route = s.findRoute(r.URL.Path[len(urlPrefix):], r)
+	}
+
+	// CORS headers are set before the checks, so that the browser can read
+	// the errors
+	if s.CORS != nil && s.CORS.handle(w, r, route) {
 		return
 	}
 
-	if len(route.method) > 0 && route.method != r.Method {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	if route == nil {
+		msg := fmt.Sprintf("route '%s' not found", r.URL.Path)
+		writeResult(w, s.responseCodec(r, nil), nil, NewError(CodeNotFound, http.StatusNotFound, msg))
 		return
 	}
 
-	arg := route.newArg()
-	if arg != nil {
-		if e := json.NewDecoder(r.Body).Decode(arg); e != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Allow", route.allow())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !route.allows(r.Method) {
+		w.Header().Set("Allow", route.allow())
+		msg := fmt.Sprintf("method '%s' is not allowed", r.Method)
+		writeResult(w, s.responseCodec(r, nil), nil, NewError(CodeMethodNotAllowed, http.StatusMethodNotAllowed, msg))
+		return
+	}
+
+	if r.Method == "HEAD" {
+		w = headWriter{w}
+	}
+
+	r = withTraceID(w, r)
+	setDeprecationHeaders(w, route)
+	s.limitBody(route, r)
+
+	var codec Codec
+	var decode func(arg interface{}) error
+
+	// arguments of GET and HEAD routes are decoded from the query string
+	if r.Method == "GET" || r.Method == "HEAD" {
+		decode = func(arg interface{}) error {
+			query := r.URL.Query()
+			if len(query) == 0 {
+				return nil
+			}
+			return DecodeValues(query, arg)
+		}
+	} else if c, e := s.requestCodec(r); e != nil {
+		writeResult(w, s.responseCodec(r, nil), nil, e)
+		return
+	} else if c == JSONCodec && s.DisallowUnknownFields {
+		codec = c
+		decode = func(arg interface{}) error {
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			return dec.Decode(arg)
 		}
+	} else {
+		codec = c
+		decode = func(arg interface{}) error {
+			return c.Decode(r.Body, arg)
+		}
+	}
+
+	if route.stream {
+		s.serveStream(name, route, w, r, decode)
+		return
+	}
+
+	respCodec := s.responseCodec(r, codec)
+	r, cc := s.withCachedCall(name, route, r, respCodec)
+	res, e := s.call(name, route, r, nil, decode)
+	if cc != nil {
+		cc.write(w, r, respCodec, res, e)
+	} else {
+		writeResult(w, respCodec, res, e)
+	}
+}
+
+// call decodes the argument with 'decode' and invokes the handler through
+// the interceptors, 'stream' is nil if the route is not a streaming route
+func (s *Server) call(name string, route *route, r *http.Request, stream *StreamWriter, decode func(arg interface{}) error) (interface{}, error) {
+	if s.Metrics == nil {
+		return s.doCall(name, route, r, stream, decode)
 	}
 
-	resp := Result{Succeeded: true}
-	res, e := route.callHandler(r, arg)
+	start := s.Metrics.begin(name)
+	res, e := s.doCall(name, route, r, stream, decode)
+	s.Metrics.end(name, start, e)
+	return res, e
+}
+
+func (s *Server) doCall(name string, route *route, r *http.Request, stream *StreamWriter, decode func(arg interface{}) error) (interface{}, error) {
+	r, e := authenticate(route, r)
 	if e != nil {
-		resp.Succeeded = false
-		resp.Message = e.Error()
+		return nil, e
+	}
+	if e := s.checkRateLimit(name, route, r); e != nil {
+		return nil, e
+	}
+	s.warnDeprecated(name, route, r)
+	if cc, _ := r.Context().Value(cachedCallKey{}).(*cachedCall); cc != nil {
+		if hit, e := cc.lookup(r); hit || e != nil {
+			return nil, e
+		}
 	}
 
+	r, cancel := withDeadline(r)
+	defer cancel()
+
+	var arg interface{}
+	if route.newArg != nil {
+		arg = route.newArg()
+		if e := decode(arg); errors.Is(e, errBodyTooLarge) {
+			return nil, errBodyTooLarge
+		} else if e != nil {
+			return nil, NewError(CodeInvalidArgument, http.StatusBadRequest, e.Error())
+		}
+		if e := Validate(arg); e != nil {
+			return nil, e
+		}
+	}
+
+	ci := &CallInfo{Route: name, Request: r, Arg: arg, Stream: stream}
+	invoke := chainInterceptors(s.interceptors, route.invoke)
+	return invoke(ci)
+}
+
+// newResult converts the return values of a handler to a result and the
+// HTTP status of the response
+func newResult(res interface{}, e error) (*Result, int) {
+	if e != nil {
+		re := toError(e)
+		status := re.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		return &Result{Code: re.Code, Message: re.Message, Data: re.Details}, status
+	}
+
+	resp := &Result{Succeeded: true}
 	switch v := res.(type) {
 	case int64:
 		resp.Data = strconv.FormatInt(v, 10)
 	case uint64:
 		resp.Data = strconv.FormatUint(v, 10)
 	default:
-		resp.Data = res
+		if s, ok := int64String(res); ok {
+			resp.Data = s
+		} else {
+			resp.Data = res
+		}
 	}
-
-	w.Header().Add("Content-Type", contentType)
-	json.NewEncoder(w).Encode(&resp)
+	return resp, http.StatusOK
 }
 
-// Call calls the specified API
-func Call(url string, arg, result interface{}) error {
-	var data []byte
+// int64String converts 'res' to a string if its kind is int64 or uint64,
+// pointers are followed, and types with their own encoding are skipped, the
+// same as Describe does
+func int64String(res interface{}) (string, bool) {
+	v := reflect.ValueOf(res)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
 
-	if arg != nil {
-		if d, e := json.Marshal(arg); e != nil {
-			return e
-		} else {
-			data = d
-		}
+	switch v.Kind() {
+	case reflect.Int64, reflect.Uint64:
+	default:
+		return "", false
 	}
 
-	resp, e := http.DefaultClient.Post(url, contentType, bytes.NewReader(data))
-	if e != nil {
-		return e
+	t := v.Type()
+	for _, m := range []reflect.Type{jsonMarshalerType, textMarshalerType} {
+		if t.Implements(m) || reflect.PtrTo(t).Implements(m) {
+			return "", false
+		}
 	}
-	defer resp.Body.Close()
 
-	r := Result{Data: result}
-	if e := json.NewDecoder(resp.Body).Decode(&r); e != nil {
-		return e
-	} else if !r.Succeeded {
-		return errors.New(r.Message)
+	if v.Kind() == reflect.Int64 {
+		return strconv.FormatInt(v.Int(), 10), true
 	}
-	return nil
+	return strconv.FormatUint(v.Uint(), 10), true
+}
+
+func writeResult(w http.ResponseWriter, codec Codec, res interface{}, e error) {
+	resp, status := newResult(res, e)
+	writeContentType(w, codec)
+	setRetryAfter(w, resp)
+	w.WriteHeader(status)
+	codec.Encode(w, resp)
+}
+
+// headWriter discards the body of the response to a HEAD request
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// DefaultServer is the server used by the package level functions
+var DefaultServer = NewServer("")
+
+// Add register a API handler to the route map of DefaultServer,
+// see Server.Add for details
+func Add(method, name string, handler interface{}, opts ...RouteOption) {
+	DefaultServer.Add(method, name, handler, opts...)
+}
+
+// ServeHTTP handles HTTP request with DefaultServer, 'urlPrefix' is used
+// instead of DefaultServer.Prefix
+func ServeHTTP(urlPrefix string, w http.ResponseWriter, r *http.Request) {
+	DefaultServer.serve(urlPrefix, w, r)
 }
@@ -0,0 +1,284 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type echoArg struct {
+	Text string `json:"text"`
+}
+
+func echo(r *http.Request, arg *echoArg) (string, error) {
+	if len(arg.Text) == 0 {
+		return "", errors.New("text is empty")
+	}
+	return arg.Text, nil
+}
+
+func newTestServer(prefix string) *Server {
+	s := NewServer(prefix)
+	s.Add("POST", "echo", echo)
+	s.Add("", "ping", func(r *http.Request) error { return nil })
+	return s
+}
+
+func Test_Server(t *testing.T) {
+	ts := httptest.NewServer(newTestServer("/api/"))
+	defer ts.Close()
+
+	var res string
+	if e := Call(ts.URL+"/api/echo", &echoArg{Text: "hello"}, &res); e != nil {
+		t.Fatal(e)
+	}
+	if res != "hello" {
+		t.Errorf("expect 'hello', got '%s'", res)
+	}
+
+	if e := Call(ts.URL+"/api/echo", &echoArg{}, &res); e == nil || e.Error() != "text is empty" {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	if e := Call(ts.URL+"/api/ping", nil, nil); e != nil {
+		t.Error(e)
+	}
+}
+
+func Test_ServerIsolation(t *testing.T) {
+	s1, s2 := newTestServer("/v1/"), NewServer("/v2/")
+	s2.Add("", "only_v2", func(r *http.Request) error { return nil })
+
+	for _, c := range []struct {
+		s      *Server
+		path   string
+		status int
+	}{
+		{s1, "/v1/ping", http.StatusOK},
+		{s1, "/v1/only_v2", http.StatusNotFound},
+		{s2, "/v2/only_v2", http.StatusOK},
+		{s2, "/v2/ping", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		c.s.ServeHTTP(w, httptest.NewRequest("POST", c.path, nil))
+		if w.Code != c.status {
+			t.Errorf("%s: expect status %d, got %d", c.path, c.status, w.Code)
+		}
+	}
+}
+
+func Test_Interceptor(t *testing.T) {
+	s := newTestServer("/")
+	var seen []string
+	s.Use(func(ci *CallInfo, next Invoker) (interface{}, error) {
+		seen = append(seen, ci.Route)
+		if ci.Request.Header.Get("X-Token") != "secret" {
+			return nil, errors.New("unauthorized")
+		}
+		return next(ci)
+	}, func(ci *CallInfo, next Invoker) (interface{}, error) {
+		if arg, ok := ci.Arg.(*echoArg); ok {
+			arg.Text += "!"
+		}
+		return next(ci)
+	})
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var res string
+	if e := Call(ts.URL+"/echo", &echoArg{Text: "hi"}, &res); e == nil || e.Error() != "unauthorized" {
+		t.Errorf("call is not short-circuited: %v", e)
+	}
+
+	DefaultClient.Interceptors = nil
+	defer func() { DefaultClient.Interceptors = nil }()
+	UseClient(func(c *ClientCall, next ClientInvoker) error {
+		c.Header.Set("X-Token", "secret")
+		return next(c)
+	})
+
+	if e := Call(ts.URL+"/echo", &echoArg{Text: "hi"}, &res); e != nil {
+		t.Fatal(e)
+	}
+	if res != "hi!" {
+		t.Errorf("expect 'hi!', got '%s'", res)
+	}
+	if len(seen) != 2 || seen[1] != "echo" {
+		t.Errorf("unexpected routes seen by interceptor: %v", seen)
+	}
+}
+
+func Test_Error(t *testing.T) {
+	s := NewServer("/")
+	s.Add("", "find", func(r *http.Request, arg *IDArg) (string, error) {
+		return "", NewError(CodeNotFound, http.StatusNotFound, "not found", arg.ID)
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	e := Call(ts.URL+"/find", &IDArg{ID: 5}, nil)
+	var re *Error
+	if !errors.As(e, &re) {
+		t.Fatalf("expect *Error, got %v", e)
+	}
+	if re.Code != CodeNotFound || re.Status != http.StatusNotFound || re.Message != "not found" {
+		t.Errorf("unexpected error: %+v", re)
+	}
+	if d, _ := re.Details.(json.RawMessage); string(d) != "5" {
+		t.Errorf("unexpected details: %v", re.Details)
+	}
+
+	e = Call(ts.URL+"/find", "bad argument", nil)
+	if !errors.As(e, &re) || re.Code != CodeInvalidArgument || re.Status != http.StatusBadRequest {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
+
+func Test_Context(t *testing.T) {
+	s := NewServer("/")
+	s.Add("", "wait", func(ctx context.Context, arg *IDArg) (bool, error) {
+		if RequestFromContext(ctx) == nil {
+			return false, errors.New("no request in context")
+		}
+		if _, ok := ctx.Deadline(); !ok {
+			return false, errors.New("no deadline")
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(time.Duration(arg.ID) * time.Millisecond):
+			return true, nil
+		}
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var ok bool
+	if e := CallContext(ctx, ts.URL+"/wait", &IDArg{ID: 1}, &ok); e != nil || !ok {
+		t.Fatalf("unexpected result: %v, %v", ok, e)
+	}
+
+	// the deadline in the header is applied to the context of the handler
+	c := NewClient(ts.URL + "/")
+	c.Header = http.Header{TimeoutHeader: []string{"50"}}
+	e := c.Call(context.Background(), "wait", &IDArg{ID: 2000}, nil)
+	var re *Error
+	if !errors.As(e, &re) || re.Code != CodeDeadlineExceeded {
+		t.Errorf("expect deadline exceeded error, got %v", e)
+	}
+}
+
+func Test_ServeErrors(t *testing.T) {
+	s := newTestServer("/")
+	s.Add("GET", "hello", func(r *http.Request) (string, error) { return "hello", nil })
+	s.Add("POST", "small", echo, WithMaxBodySize(8))
+	s.DisallowUnknownFields = true
+
+	for _, c := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"POST", "/unknown", "", http.StatusNotFound, CodeNotFound},
+		{"GET", "/echo", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"POST", "/small", `{"text":"hello"}`, http.StatusRequestEntityTooLarge, CodeInvalidArgument},
+		{"POST", "/echo", `{"text":"a","more":1}`, http.StatusBadRequest, CodeInvalidArgument},
+		{"POST", "/echo", `{"text":"a"}`, http.StatusOK, ""},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		r.Header.Set("Content-Type", "application/json")
+		s.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s %s: expect status %d, got %d", c.method, c.path, c.status, w.Code)
+			continue
+		}
+		if len(c.code) == 0 {
+			continue
+		}
+		var res Result
+		if e := json.Unmarshal(w.Body.Bytes(), &res); e != nil || res.Code != c.code {
+			t.Errorf("%s %s: unexpected result %s", c.method, c.path, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/echo", nil))
+	if w.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("unexpected Allow header '%s'", w.Header().Get("Allow"))
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("HEAD", "/hello", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("unexpected HEAD response %d '%s'", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/hello", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("unexpected OPTIONS response %d, Allow '%s'", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func Test_CORS(t *testing.T) {
+	s := newTestServer("/")
+	s.CORS = &CORS{AllowedOrigins: []string{"https://example.com"}, MaxAge: time.Hour}
+
+	r := httptest.NewRequest("OPTIONS", "/echo", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	h := w.Header()
+	if w.Code != http.StatusNoContent ||
+		h.Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		h.Get("Access-Control-Allow-Methods") != "POST, OPTIONS" ||
+		h.Get("Access-Control-Allow-Headers") != "Content-Type" ||
+		h.Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("unexpected preflight response %d, %v", w.Code, h)
+	}
+
+	r = httptest.NewRequest("POST", "/echo", strings.NewReader(`{"text":"a"}`))
+	r.Header.Set("Origin", "https://other.com")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if len(w.Header().Get("Access-Control-Allow-Origin")) > 0 {
+		t.Error("origin should not be allowed")
+	}
+
+	// errors of the route lookup carry the CORS headers too
+	for _, c := range []struct {
+		method, path string
+		status       int
+	}{
+		{"POST", "/missing", http.StatusNotFound},
+		{"OPTIONS", "/missing", http.StatusNotFound},
+		{"GET", "/echo", http.StatusMethodNotAllowed},
+	} {
+		r = httptest.NewRequest(c.method, c.path, nil)
+		r.Header.Set("Origin", "https://example.com")
+		if c.method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		h := w.Header()
+		if w.Code != c.status || h.Get("Access-Control-Allow-Origin") != "https://example.com" ||
+			len(h.Get("Access-Control-Allow-Methods")) > 0 {
+			t.Errorf("%s %s: unexpected response %d, %v", c.method, c.path, w.Code, h)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Command rpcgen generates typed Go or TypeScript clients, or an OpenAPI 3
+// document, from the output of a describe endpoint, see rpc.ServeDescribe
+//
+// Usage:
+//
+//	rpcgen -src http://localhost:8080/api/_describe -lang go -pkg api -o api/client.go
+//	rpcgen -src describe.json -lang ts -o web/src/api.ts
+//	rpcgen -src describe.json -lang openapi -title "My API" -server https://example.com/api
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/localvar/go-utils/rpc"
+	"github.com/localvar/go-utils/rpc/clientgen"
+)
+
+func loadDesc(src string) (*rpc.APIDesc, error) {
+	var r io.ReadCloser
+
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, e := http.Get(src)
+		if e != nil {
+			return nil, e
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("get '%s': %s", src, resp.Status)
+		}
+		r = resp.Body
+	} else if f, e := os.Open(src); e != nil {
+		return nil, e
+	} else {
+		r = f
+	}
+	defer r.Close()
+
+	desc := &rpc.APIDesc{}
+	if e := json.NewDecoder(r).Decode(desc); e != nil {
+		return nil, e
+	}
+	return desc, nil
+}
+
+func main() {
+	src := flag.String("src", "", "URL of the describe endpoint, or path of a file saved from it")
+	lang := flag.String("lang", "go", "language of the generated client, 'go' or 'ts', or 'openapi' for an OpenAPI 3 document")
+	pkg := flag.String("pkg", "api", "package name of the generated Go client")
+	title := flag.String("title", "API", "title of the OpenAPI document")
+	version := flag.String("version", "1.0.0", "version of the OpenAPI document")
+	server := flag.String("server", "", "URL where the routes are mounted, used by the OpenAPI document")
+	out := flag.String("o", "", "output file, default is stdout")
+	flag.Parse()
+
+	if len(*src) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	gen := func(w io.Writer, desc *rpc.APIDesc) error {
+		switch *lang {
+		case "go":
+			return clientgen.Go(w, desc, *pkg)
+		case "ts":
+			return clientgen.TypeScript(w, desc)
+		case "openapi":
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(desc.OpenAPI(*title, *version, *server))
+		}
+		return fmt.Errorf("unsupported language '%s'", *lang)
+	}
+
+	if e := run(*src, *out, gen); e != nil {
+		fmt.Fprintln(os.Stderr, e)
+		os.Exit(1)
+	}
+}
+
+// run loads the description from 'src', and writes the output of 'gen' to
+// file 'out', or stdout if it is empty
+func run(src, out string, gen func(w io.Writer, desc *rpc.APIDesc) error) error {
+	desc, e := loadDesc(src)
+	if e != nil {
+		return e
+	}
+
+	if len(out) == 0 {
+		return gen(os.Stdout, desc)
+	}
+
+	f, e := os.Create(out)
+	if e != nil {
+		return e
+	}
+	if e = gen(f, desc); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
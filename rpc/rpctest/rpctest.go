@@ -0,0 +1,245 @@
+// Package rpctest provides utilities to test rpc handlers in process,
+// without listening on a network address
+package rpctest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/localvar/go-utils/rpc"
+)
+
+// Call is a call recorded by a Server
+type Call struct {
+	Route  string
+	Header http.Header
+	Arg    interface{} // decoded argument, nil if the handler has no argument
+	Result interface{}
+	Err    error
+}
+
+// Server is an in-process rpc.Server for tests, it records the calls to
+// its routes
+type Server struct {
+	*rpc.Server
+	lock  sync.Mutex
+	calls []Call
+}
+
+// NewServer creates a server mounted at '/', and registers routes with
+// 'register', e.g. the function which registers the routes of a service
+func NewServer(register ...func(s *rpc.Server)) *Server {
+	s := &Server{Server: rpc.NewServer("/")}
+	s.Use(s.record)
+	for _, fn := range register {
+		fn(s.Server)
+	}
+	return s
+}
+
+func (s *Server) record(ci *rpc.CallInfo, next rpc.Invoker) (interface{}, error) {
+	res, e := next(ci)
+	s.lock.Lock()
+	s.calls = append(s.calls, Call{
+		Route:  ci.Route,
+		Header: ci.Request.Header,
+		Arg:    ci.Arg,
+		Result: res,
+		Err:    e,
+	})
+	s.lock.Unlock()
+	return res, e
+}
+
+// Calls returns the recorded calls to 'route', or all calls if 'route' is
+// empty
+func (s *Server) Calls(route string) []Call {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var calls []Call
+	for _, c := range s.calls {
+		if len(route) == 0 || c.Route == route {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset clears the recorded calls
+func (s *Server) Reset() {
+	s.lock.Lock()
+	s.calls = nil
+	s.lock.Unlock()
+}
+
+// serve serves 'req' in process, 'path' is the path of the route relative
+// to the server
+func (s *Server) serve(req *http.Request, path string) *http.Response {
+	r := req.Clone(req.Context())
+	r.URL.Path = s.Prefix + path
+	r.URL.RawPath = ""
+	r.RequestURI = r.URL.RequestURI()
+	r.RemoteAddr = "192.0.2.1:1234"
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	resp := w.Result()
+	resp.Request = req
+	return resp
+}
+
+// transport serves the requests to 'host' whose paths start with 'path'
+// with a Server, and sends other requests with 'next'
+type transport struct {
+	host   string
+	path   string
+	server *Server
+	next   http.RoundTripper
+}
+
+func newTransport(baseURL string, s *Server, next http.RoundTripper) (*transport, error) {
+	u, e := url.Parse(baseURL)
+	if e != nil {
+		return nil, e
+	}
+	path := u.Path
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return &transport{host: u.Host, path: path, server: s, next: next}, nil
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host || !strings.HasPrefix(req.URL.Path, t.path) {
+		return t.next.RoundTrip(req)
+	}
+	return t.server.serve(req, req.URL.Path[len(t.path):]), nil
+}
+
+const baseURL = "http://rpctest/"
+
+// Client returns a client whose calls are served by the server in process
+func (s *Server) Client() *rpc.Client {
+	t, _ := newTransport(baseURL, s, http.DefaultTransport)
+	c := rpc.NewClient(baseURL)
+	c.HTTPClient = &http.Client{Transport: t}
+	return c
+}
+
+// Call calls 'route' with 'arg', and decodes the result into 'result'
+func (s *Server) Call(ctx context.Context, route string, arg, result interface{}) error {
+	return s.Client().CallMethod(ctx, s.method(route), route, arg, result)
+}
+
+// method returns the HTTP method of a call to 'route', it is the method of
+// the route, or POST if the route accepts any method
+func (s *Server) method(route string) string {
+	if m, _ := s.RouteMethod(route); len(m) > 0 {
+		return m
+	}
+	return "POST"
+}
+
+// Invoke calls 'route' of 's' with 'arg', and returns the typed result
+func Invoke[Res any](ctx context.Context, s *Server, route string, arg interface{}) (Res, error) {
+	var res Res
+	e := s.Call(ctx, route, arg, &res)
+	return res, e
+}
+
+// Response is the raw response of a call
+type Response struct {
+	Status int
+	Header http.Header
+	Result rpc.Result // 'Data' is a json.RawMessage
+}
+
+// Decode decodes the data of the result into 'v'
+func (r *Response) Decode(v interface{}) error {
+	data, _ := r.Result.Data.(json.RawMessage)
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Do calls 'route' with 'arg' encoded as JSON, and returns the raw
+// response, the request uses the method of the route, and the argument of
+// GET and HEAD routes is sent in the query string
+func (s *Server) Do(ctx context.Context, route string, arg interface{}) (*Response, error) {
+	method := s.method(route)
+	var body io.Reader = http.NoBody
+	query := ""
+	if arg != nil && method != "GET" && method != "HEAD" {
+		data, e := json.Marshal(arg)
+		if e != nil {
+			return nil, e
+		}
+		body = bytes.NewReader(data)
+	} else if arg != nil {
+		values, e := rpc.EncodeValues(arg)
+		if e != nil {
+			return nil, e
+		}
+		if len(values) > 0 {
+			query = "?" + values.Encode()
+		}
+	}
+
+	req, e := http.NewRequestWithContext(ctx, method, baseURL+route+query, body)
+	if e != nil {
+		return nil, e
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp := s.serve(req, route)
+	defer resp.Body.Close()
+
+	r := &Response{Status: resp.StatusCode, Header: resp.Header}
+	var raw struct {
+		rpc.Result
+		Data json.RawMessage `json:"data"`
+	}
+	if e := json.NewDecoder(resp.Body).Decode(&raw); e != nil {
+		return r, e
+	}
+	r.Result = raw.Result
+	if len(raw.Data) > 0 {
+		r.Result.Data = raw.Data
+	}
+	return r, nil
+}
+
+// Stub serves the calls of rpc.Call and rpc.CallContext to the URLs which
+// start with 'baseURL' with 's', e.g. to stub the routes of a remote
+// service, until the end of 't'. Tests which stub routes should not run
+// in parallel.
+func Stub(t testing.TB, baseURL string, s *Server) {
+	old := rpc.DefaultClient.HTTPClient
+	next := http.DefaultTransport
+	if old != nil && old.Transport != nil {
+		next = old.Transport
+	}
+
+	tr, e := newTransport(baseURL, s, next)
+	if e != nil {
+		t.Fatal(e)
+	}
+	hc := &http.Client{Transport: tr}
+	if old != nil {
+		hc.Timeout = old.Timeout
+	}
+	rpc.DefaultClient.HTTPClient = hc
+	t.Cleanup(func() { rpc.DefaultClient.HTTPClient = old })
+}
@@ -0,0 +1,103 @@
+package rpctest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/localvar/go-utils/rpc"
+)
+
+type addArg struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func register(s *rpc.Server) {
+	s.Add("POST", "add", func(r *http.Request, arg *addArg) (int, error) {
+		return arg.A + arg.B, nil
+	})
+	s.Add("GET", "neg", func(r *http.Request, arg *addArg) (int, error) {
+		if arg.A == 0 {
+			return 0, rpc.NewError(rpc.CodeInvalidArgument, http.StatusBadRequest, "a is zero")
+		}
+		return -arg.A, nil
+	})
+	s.Add("PUT", "put", func(r *http.Request, arg *addArg) (int, error) {
+		return arg.A * arg.B, nil
+	})
+	// total calls the 'add' route of a remote service with rpc.Call
+	s.Add("POST", "total", func(r *http.Request, arg *addArg) (int, error) {
+		var sum int
+		e := rpc.Call("http://calc.example.com/api/add", arg, &sum)
+		return sum * 10, e
+	})
+}
+
+func Test_Server(t *testing.T) {
+	s := NewServer(register)
+	ctx := context.Background()
+
+	if res, e := Invoke[int](ctx, s, "add", &addArg{A: 1, B: 2}); e != nil || res != 3 {
+		t.Errorf("unexpected result %d, %v", res, e)
+	}
+
+	resp, e := s.Do(ctx, "neg", &addArg{A: 5})
+	var res int
+	if e != nil || resp.Status != http.StatusOK || !resp.Result.Succeeded || resp.Decode(&res) != nil || res != -5 {
+		t.Errorf("unexpected response %+v, %v", resp, e)
+	}
+
+	resp, e = s.Do(ctx, "neg", &addArg{})
+	if e != nil || resp.Status != http.StatusBadRequest || resp.Result.Code != rpc.CodeInvalidArgument {
+		t.Errorf("unexpected response %+v, %v", resp, e)
+	}
+
+	calls := s.Calls("neg")
+	if len(calls) != 2 || calls[0].Arg.(*addArg).A != 5 || calls[0].Result != -5 || calls[1].Err == nil {
+		t.Errorf("unexpected calls: %+v", calls)
+	}
+	if len(s.Calls("")) != 3 {
+		t.Errorf("expect 3 calls, got %d", len(s.Calls("")))
+	}
+	s.Reset()
+	if len(s.Calls("")) != 0 {
+		t.Error("calls are not cleared")
+	}
+
+	if res, e := Invoke[int](ctx, s, "neg", &addArg{A: 4}); e != nil || res != -4 {
+		t.Errorf("unexpected result %d, %v", res, e)
+	}
+
+	// routes are called with their methods
+	if res, e := Invoke[int](ctx, s, "put", &addArg{A: 2, B: 3}); e != nil || res != 6 {
+		t.Errorf("unexpected result %d, %v", res, e)
+	}
+	resp, e = s.Do(ctx, "put", &addArg{A: 3, B: 3})
+	if e != nil || resp.Status != http.StatusOK || resp.Decode(&res) != nil || res != 9 {
+		t.Errorf("unexpected response %+v, %v", resp, e)
+	}
+}
+
+func Test_Stub(t *testing.T) {
+	remote := NewServer()
+	remote.Add("POST", "add", func(r *http.Request, arg *addArg) (int, error) {
+		if arg.A < 0 {
+			return 0, errors.New("negative")
+		}
+		return 42, nil
+	})
+	Stub(t, "http://calc.example.com/api/", remote)
+
+	s := NewServer(register)
+	if res, e := Invoke[int](context.Background(), s, "total", &addArg{A: 1}); e != nil || res != 420 {
+		t.Errorf("unexpected result %d, %v", res, e)
+	}
+	if _, e := Invoke[int](context.Background(), s, "total", &addArg{A: -1}); e == nil || e.Error() != "negative" {
+		t.Errorf("unexpected error: %v", e)
+	}
+	if calls := remote.Calls("add"); len(calls) != 2 {
+		t.Errorf("expect 2 calls, got %d", len(calls))
+	}
+}
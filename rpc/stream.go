@@ -0,0 +1,344 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// CodeStreamEnd is the code of the last message of a stream which ends
+// without an error
+const CodeStreamEnd = "stream_end"
+
+const (
+	ndjsonType = "application/x-ndjson"
+	sseType    = "text/event-stream"
+)
+
+var streamWriterType = reflect.TypeOf((*StreamWriter)(nil))
+
+// ErrStreamClosed is returned by StreamWriter.Send after the handler
+// returns
+var ErrStreamClosed = errors.New("stream is closed")
+
+// StreamWriter sends the results of a streaming handler to the client.
+// Every message is a Result encoded in JSON, sent as an event of
+// Server-Sent Events if the client accepts 'text/event-stream', or as a
+// line of newline-delimited JSON otherwise. After the handler returns, a
+// failed result is sent if it returns an error, or a result with code
+// CodeStreamEnd is sent. If the handler fails before sending anything,
+// the error is sent as a normal response.
+type StreamWriter struct {
+	w       http.ResponseWriter
+	ctx     context.Context
+	sse     bool
+	lock    sync.Mutex
+	started bool
+	closed  bool
+}
+
+func newStreamWriter(w http.ResponseWriter, r *http.Request) *StreamWriter {
+	sw := &StreamWriter{w: w, ctx: r.Context()}
+	for _, v := range r.Header["Accept"] {
+		if strings.Contains(v, sseType) {
+			sw.sse = true
+		}
+	}
+	return sw
+}
+
+// Send sends 'v' as the data of a succeeded result, it is safe to call
+// Send concurrently
+func (sw *StreamWriter) Send(v interface{}) error {
+	res, _ := newResult(v, nil)
+	return sw.write(res)
+}
+
+func (sw *StreamWriter) write(res *Result) error {
+	data, e := json.Marshal(res)
+	if e != nil {
+		return e
+	}
+
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+
+	if sw.closed {
+		return ErrStreamClosed
+	}
+	if e := sw.ctx.Err(); e != nil {
+		return e
+	}
+
+	if !sw.started {
+		sw.started = true
+		h := sw.w.Header()
+		if sw.sse {
+			h.Set("Content-Type", sseType)
+		} else {
+			h.Set("Content-Type", ndjsonType)
+		}
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		sw.w.WriteHeader(http.StatusOK)
+	}
+
+	var buf bytes.Buffer
+	if sw.sse {
+		buf.WriteString("data: ")
+		buf.Write(data)
+		buf.WriteString("\n\n")
+	} else {
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	if _, e := sw.w.Write(buf.Bytes()); e != nil {
+		return e
+	}
+
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// close sends the last message of the stream, it returns false if nothing
+// has been sent and the handler fails, the error should be sent as a
+// normal response in this case
+func (sw *StreamWriter) close(e error) bool {
+	sw.lock.Lock()
+	started := sw.started
+	sw.lock.Unlock()
+
+	if e != nil && !started {
+		sw.lock.Lock()
+		sw.closed = true
+		sw.lock.Unlock()
+		return false
+	}
+
+	res := &Result{Succeeded: true, Code: CodeStreamEnd}
+	if e != nil {
+		res, _ = newResult(nil, e)
+	}
+	sw.write(res)
+
+	sw.lock.Lock()
+	sw.closed = true
+	sw.lock.Unlock()
+	return true
+}
+
+func (s *Server) serveStream(name string, route *route, w http.ResponseWriter, r *http.Request, decode func(arg interface{}) error) {
+	sw := newStreamWriter(w, r)
+	_, e := s.call(name, route, r, sw, decode)
+	if !sw.close(e) {
+		writeResult(w, s.responseCodec(r, nil), nil, e)
+	}
+}
+
+// StreamReader reads the results of a streaming route, it should be closed
+// after use
+//
+//	sr, e := client.Stream(ctx, "progress", arg)
+//	if e != nil {
+//		return e
+//	}
+//	defer sr.Close()
+//	for sr.Next() {
+//		var p Progress
+//		if e := sr.Decode(&p); e != nil {
+//			return e
+//		}
+//	}
+//	return sr.Err()
+type StreamReader struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	cancel context.CancelFunc
+	sse    bool
+	data   json.RawMessage
+	err    error
+	done   bool
+}
+
+// Next reads the next result, it returns false at the end of the stream
+// or if there's an error, see Err
+func (sr *StreamReader) Next() bool {
+	if sr.done {
+		return false
+	}
+
+	msg, e := sr.readMessage()
+	if e != nil {
+		if e == io.EOF {
+			e = io.ErrUnexpectedEOF
+		}
+		return sr.finish(e)
+	}
+
+	var r rawResult
+	if e := json.Unmarshal(msg, &r); e != nil {
+		return sr.finish(e)
+	}
+	if !r.Succeeded {
+		return sr.finish(r.decode(nil, http.StatusOK))
+	}
+	if r.Code == CodeStreamEnd {
+		return sr.finish(nil)
+	}
+
+	sr.data = r.Data
+	return true
+}
+
+func (sr *StreamReader) finish(e error) bool {
+	sr.done, sr.err, sr.data = true, e, nil
+	return false
+}
+
+// readMessage reads the JSON text of the next message
+func (sr *StreamReader) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		line, e := sr.reader.ReadBytes('\n')
+		if e != nil && (e != io.EOF || len(line) == 0) {
+			return nil, e
+		}
+		line = bytes.TrimRight(line, "\r\n")
+
+		if !sr.sse {
+			if len(line) > 0 {
+				return line, nil
+			}
+			continue
+		}
+
+		// an event ends with an empty line, only the data field is used
+		if len(line) == 0 {
+			if len(msg) > 0 {
+				return msg, nil
+			}
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("data:")) {
+			line = bytes.TrimPrefix(line[5:], []byte(" "))
+			if len(msg) > 0 {
+				msg = append(msg, '\n')
+			}
+			msg = append(msg, line...)
+		}
+	}
+}
+
+// Decode decodes the data of the current result into 'v'
+func (sr *StreamReader) Decode(v interface{}) error {
+	if len(sr.data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(sr.data, v)
+}
+
+// Err returns the error which stops the stream, an *Error is returned if
+// the handler fails
+func (sr *StreamReader) Err() error {
+	return sr.err
+}
+
+// Close closes the stream, it can be called before the end of the stream
+func (sr *StreamReader) Close() error {
+	sr.done = true
+	e := sr.body.Close()
+	sr.cancel()
+	return e
+}
+
+// Stream calls a streaming route, the interceptors of the client are
+// applied, and 'Result' of the ClientCall is the StreamReader. The call is
+// not retried, and 'Timeout' of the client limits the whole stream.
+func (c *Client) Stream(ctx context.Context, route string, arg interface{}) (*StreamReader, error) {
+	return c.StreamMethod(ctx, "POST", route, arg)
+}
+
+// StreamMethod is Stream with HTTP method 'method', see CallMethod
+func (c *Client) StreamMethod(ctx context.Context, method, route string, arg interface{}) (*StreamReader, error) {
+	sr := &StreamReader{cancel: func() {}}
+	if c.Timeout > 0 {
+		ctx, sr.cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+
+	cc := &ClientCall{
+		Context: ctx,
+		Method:  method,
+		Route:   route,
+		URL:     c.BaseURL + route,
+		Arg:     arg,
+		Result:  sr,
+		Header:  make(http.Header),
+	}
+	for k, v := range c.Header {
+		cc.Header[k] = v
+	}
+
+	if e := chainClientInterceptors(c.Interceptors, c.openStream)(cc); e != nil {
+		sr.cancel()
+		return nil, e
+	}
+	return sr, nil
+}
+
+// openStream sends the request of a streaming call
+func (c *Client) openStream(cc *ClientCall) error {
+	req, e := newRequest(cc, JSONCodec)
+	if e != nil {
+		return e
+	}
+	for k, v := range cc.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", ndjsonType+", "+sseType+";q=0.9, application/json;q=0.5")
+	setTimeoutHeader(cc.Context, req.Header)
+	setTraceHeader(cc.Context, req.Header)
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	resp, e := hc.Do(req)
+	if e != nil {
+		return e
+	}
+
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt != ndjsonType && mt != sseType {
+		// the call fails before the stream starts
+		defer func() {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+		if e := decodeResult(resp, nil); e != nil {
+			return e
+		}
+		return errors.New("route '" + cc.Route + "' is not a streaming route")
+	}
+
+	sr := cc.Result.(*StreamReader)
+	sr.body, sr.reader, sr.sse = resp.Body, bufio.NewReader(resp.Body), mt == sseType
+	return nil
+}
+
+// Stream calls a streaming route with DefaultClient
+func Stream(ctx context.Context, url string, arg interface{}) (*StreamReader, error) {
+	return DefaultClient.Stream(ctx, url, arg)
+}
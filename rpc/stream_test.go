@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type countArg struct {
+	N    int  `json:"n"`
+	Fail bool `json:"fail"`
+}
+
+func newStreamServer() *Server {
+	s := NewServer("/")
+	s.Add("", "count", func(ctx context.Context, arg *countArg, stream *StreamWriter) error {
+		if arg.N < 0 {
+			return NewError(CodeInvalidArgument, http.StatusBadRequest, "n is negative")
+		}
+		for i := 0; i < arg.N; i++ {
+			if e := stream.Send(i); e != nil {
+				return e
+			}
+		}
+		if arg.Fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	return s
+}
+
+func Test_Stream(t *testing.T) {
+	ts := httptest.NewServer(newStreamServer())
+	defer ts.Close()
+	c := NewClient(ts.URL + "/")
+
+	read := func(arg *countArg) ([]int, error) {
+		sr, e := c.Stream(context.Background(), "count", arg)
+		if e != nil {
+			return nil, e
+		}
+		defer sr.Close()
+
+		var items []int
+		for sr.Next() {
+			var i int
+			if e := sr.Decode(&i); e != nil {
+				return nil, e
+			}
+			items = append(items, i)
+		}
+		return items, sr.Err()
+	}
+
+	items, e := read(&countArg{N: 3})
+	if e != nil || len(items) != 3 || items[2] != 2 {
+		t.Errorf("unexpected result: %v, %v", items, e)
+	}
+
+	items, e = read(&countArg{N: 2, Fail: true})
+	if e == nil || e.Error() != "failed" || len(items) != 2 {
+		t.Errorf("unexpected result: %v, %v", items, e)
+	}
+
+	// fails before the stream starts
+	var re *Error
+	if _, e = read(&countArg{N: -1}); !errors.As(e, &re) || re.Status != http.StatusBadRequest {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	if items, e = read(&countArg{}); e != nil || len(items) != 0 {
+		t.Errorf("unexpected result: %v, %v", items, e)
+	}
+}
+
+func Test_StreamSSE(t *testing.T) {
+	s := newStreamServer()
+	r := httptest.NewRequest("POST", "/count", strings.NewReader(`{"n":2}`))
+	r.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type '%s'", ct)
+	}
+	expect := `data: {"succeeded":true,"data":0}` + "\n\n" +
+		`data: {"succeeded":true,"data":1}` + "\n\n" +
+		`data: {"succeeded":true,"code":"stream_end"}` + "\n\n"
+	if body := w.Body.String(); body != expect {
+		t.Errorf("unexpected body:\n%s", body)
+	}
+}
@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// TraceHeader is the HTTP header which carries the trace ID of a call, the
+// server accepts it from the request or generates one, and sends it back
+// in the response
+const TraceHeader = "X-Trace-Id"
+
+type traceKey struct{}
+
+// WithTraceID returns a copy of 'ctx' which carries trace ID 'id', calls
+// made by a Client with the context propagate the ID to the server
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceKey{}, id)
+}
+
+// TraceIDFromContext returns the trace ID carried by 'ctx', the context of
+// a call always has one, so handlers can pass it to their outgoing calls
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
+
+// NewTraceID generates a random trace ID
+func NewTraceID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// withTraceID returns a copy of 'r' whose context carries the trace ID of
+// the request, and sets the ID to the response header
+func withTraceID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(TraceHeader)
+	if len(id) == 0 || len(id) > 128 {
+		id = NewTraceID()
+	}
+	w.Header().Set(TraceHeader, id)
+	return r.WithContext(WithTraceID(r.Context(), id))
+}
+
+// setTraceHeader propagates the trace ID of 'ctx' to the server
+func setTraceHeader(ctx context.Context, h http.Header) {
+	if id := TraceIDFromContext(ctx); len(id) > 0 {
+		h.Set(TraceHeader, id)
+	}
+}
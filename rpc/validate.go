@@ -0,0 +1,315 @@
+package rpc
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError is the validation error of a field
+type FieldError struct {
+	Field   string `json:"field"` // JSON path of the field, e.g. 'items[0].name'
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by Validate if any field is invalid
+type ValidationError []FieldError
+
+func (ve ValidationError) Error() string {
+	msgs := make([]string, 0, len(ve))
+	for _, fe := range ve {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "invalid argument: " + strings.Join(msgs, "; ")
+}
+
+var emailRegexp = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+
+type rule struct {
+	name  string
+	param string
+	num   float64  // parsed param of 'min' and 'max'
+	set   []string // parsed param of 'oneof'
+}
+
+type fieldRules struct {
+	index    int
+	name     string // JSON name
+	embedded bool   // fields of an embedded struct are promoted
+	rules    []rule
+}
+
+// rulesCache caches the []fieldRules of struct types
+var rulesCache sync.Map
+
+func parseRules(tag string) ([]rule, error) {
+	var rules []rule
+
+	for _, s := range strings.Split(tag, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+
+		r := rule{name: s}
+		if i := strings.IndexByte(s, '='); i >= 0 {
+			r.name, r.param = s[:i], s[i+1:]
+		}
+
+		switch r.name {
+		case "required", "email":
+			if len(r.param) > 0 {
+				return nil, fmt.Errorf("rule '%s' does not accept parameter", r.name)
+			}
+		case "min", "max":
+			n, e := strconv.ParseFloat(r.param, 64)
+			if e != nil {
+				return nil, fmt.Errorf("invalid parameter of rule '%s': %v", r.name, e)
+			}
+			r.num = n
+		case "oneof":
+			r.set = strings.Fields(r.param)
+			if len(r.set) == 0 {
+				return nil, fmt.Errorf("rule 'oneof' requires parameter")
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule '%s'", r.name)
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+func jsonName(f *reflect.StructField) string {
+	name := f.Tag.Get("json")
+	if i := strings.IndexByte(name, ','); i >= 0 {
+		name = name[:i]
+	}
+	if len(name) == 0 || name == "-" {
+		name = f.Name
+	}
+	return name
+}
+
+// getRules returns the validation rules of the fields of struct type 't'
+func getRules(t reflect.Type) ([]fieldRules, error) {
+	if v, ok := rulesCache.Load(t); ok {
+		return v.([]fieldRules), nil
+	}
+
+	var frs []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 && !f.Anonymous {
+			continue // unexported
+		}
+		rules, e := parseRules(f.Tag.Get("validate"))
+		if e != nil {
+			return nil, fmt.Errorf("field '%s' of '%v': %v", f.Name, t, e)
+		}
+		frs = append(frs, fieldRules{
+			index:    i,
+			name:     jsonName(&f),
+			embedded: f.Anonymous && len(f.Tag.Get("json")) == 0,
+			rules:    rules,
+		})
+	}
+
+	rulesCache.Store(t, frs)
+	return frs, nil
+}
+
+// checkRules checks the validation rules of type 't' and the types of
+// its fields, it is called when a handler is registered, so that invalid
+// rules are found as early as possible
+func checkRules(t reflect.Type, checked map[reflect.Type]bool) error {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+			continue
+		}
+		break
+	}
+
+	if t.Kind() != reflect.Struct || checked[t] {
+		return nil
+	}
+	checked[t] = true
+
+	if _, e := getRules(t); e != nil {
+		return e
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if e := checkRules(t.Field(i).Type, checked); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Validate validates 'v' according to the 'validate' tags of its fields,
+// nested structs, including those in pointers, slices and maps, are also
+// validated. The supported rules are:
+//
+//	required    the field must not be zero, nil or empty
+//	min=N       minimal value of a number, or minimal length of a string,
+//	            slice or map
+//	max=N       maximal value of a number, or maximal length of a string,
+//	            slice or map
+//	email       the field must be an email address
+//	oneof=a b   the field must be one of the space separated values
+//
+// it returns nil or a ValidationError
+func Validate(v interface{}) error {
+	var ve ValidationError
+	validateValue(reflect.ValueOf(v), "", &ve)
+	if len(ve) > 0 {
+		return ve
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, ve *ValidationError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		validateStruct(v, path, ve)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), ve)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			validateValue(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), ve)
+		}
+	}
+}
+
+func validateStruct(v reflect.Value, path string, ve *ValidationError) {
+	frs, e := getRules(v.Type())
+	if e != nil {
+		panic(e)
+	}
+
+	for _, fr := range frs {
+		fv := v.Field(fr.index)
+		fpath := fr.name
+		if fr.embedded {
+			fpath = path
+		} else if len(path) > 0 {
+			fpath = path + "." + fr.name
+		}
+
+		valid := true
+		for i := range fr.rules {
+			if msg := checkRule(&fr.rules[i], fv); len(msg) > 0 {
+				*ve = append(*ve, FieldError{Field: fpath, Message: msg})
+				valid = false
+				break
+			}
+		}
+
+		if valid {
+			validateValue(fv, fpath, ve)
+		}
+	}
+}
+
+// checkRule returns an error message if 'v' violates rule 'r'
+func checkRule(r *rule, v reflect.Value) string {
+	if r.name == "required" {
+		if isEmpty(v) {
+			return "is required"
+		}
+		return ""
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "" // only 'required' applies to nil values
+		}
+		v = v.Elem()
+	}
+
+	switch r.name {
+	case "min", "max":
+		n, isLen := 0.0, false
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		case reflect.String:
+			n, isLen = float64(utf8.RuneCountInString(v.String())), true
+		case reflect.Slice, reflect.Array, reflect.Map:
+			n, isLen = float64(v.Len()), true
+		default:
+			return ""
+		}
+
+		if r.name == "min" && n < r.num {
+			if isLen {
+				return "length must be at least " + r.param
+			}
+			return "must be at least " + r.param
+		}
+		if r.name == "max" && n > r.num {
+			if isLen {
+				return "length must be at most " + r.param
+			}
+			return "must be at most " + r.param
+		}
+
+	case "email":
+		if v.Kind() == reflect.String && len(v.String()) > 0 && !emailRegexp.MatchString(v.String()) {
+			return "must be an email address"
+		}
+
+	case "oneof":
+		var s string
+		switch v.Kind() {
+		case reflect.String:
+			s = v.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			s = strconv.FormatInt(v.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			s = strconv.FormatUint(v.Uint(), 10)
+		default:
+			return ""
+		}
+		for _, item := range r.set {
+			if item == s {
+				return ""
+			}
+		}
+		return "must be one of [" + r.param + "]"
+	}
+
+	return ""
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
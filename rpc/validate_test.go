@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type validateItem struct {
+	Name string `json:"name" validate:"required,max=5"`
+}
+
+type validateArg struct {
+	Email string          `json:"email" validate:"required,email"`
+	Age   int             `json:"age" validate:"min=1,max=100"`
+	Kind  string          `json:"kind" validate:"oneof=a b"`
+	Items []*validateItem `json:"items" validate:"min=1"`
+}
+
+func Test_Validate(t *testing.T) {
+	arg := &validateArg{
+		Email: "abc",
+		Age:   0,
+		Kind:  "c",
+		Items: []*validateItem{{Name: "ok"}, {Name: "too long"}, {}},
+	}
+
+	e := Validate(arg)
+	ve, ok := e.(ValidationError)
+	if !ok {
+		t.Fatalf("expect ValidationError, got %v", e)
+	}
+
+	expect := map[string]string{
+		"email":         "must be an email address",
+		"age":           "must be at least 1",
+		"kind":          "must be one of [a b]",
+		"items[1].name": "length must be at most 5",
+		"items[2].name": "is required",
+	}
+	if len(ve) != len(expect) {
+		t.Fatalf("unexpected errors: %v", ve)
+	}
+	for _, fe := range ve {
+		if expect[fe.Field] != fe.Message {
+			t.Errorf("%s: unexpected message '%s'", fe.Field, fe.Message)
+		}
+	}
+
+	arg = &validateArg{Email: "a@b.com", Age: 20, Kind: "a", Items: []*validateItem{{Name: "ok"}}}
+	if e = Validate(arg); e != nil {
+		t.Error(e)
+	}
+}
+
+func Test_ServeValidate(t *testing.T) {
+	s := NewServer("/")
+	s.Add("POST", "add", func(r *http.Request, arg *validateArg) error { return nil })
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/add", strings.NewReader(`{"email":"a@b.com"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expect status 400, got %d", w.Code)
+	}
+
+	var res struct {
+		Succeeded bool         `json:"succeeded"`
+		Data      []FieldError `json:"data"`
+	}
+	if e := json.NewDecoder(w.Body).Decode(&res); e != nil {
+		t.Fatal(e)
+	}
+	if res.Succeeded || len(res.Data) != 3 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("invalid rule is not detected")
+		}
+	}()
+	s.Add("POST", "bad", func(r *http.Request, arg *struct {
+		N int `validate:"min=x"`
+	}) error {
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// VersionHeader is the HTTP header which selects the version of a route, a
+// version can also be selected by the path, e.g. '/api/v2/echo'
+const VersionHeader = "Api-Version"
+
+// WithVersion registers the route as version 'v' of the route, it is
+// registered with name 'v/name', so it can be called by the path, or by
+// the name and VersionHeader. The route registered without a version
+// serves the requests which do not select a version, e.g. the old apps.
+func WithVersion(v string) RouteOption {
+	return func(r *route) {
+		r.version = v
+	}
+}
+
+// Deprecation describes a deprecated route
+type Deprecation struct {
+	Since  time.Time // when the route was deprecated, zero means unknown
+	Sunset time.Time // when the route will be removed, zero means unknown
+	Link   string    // URL of the migration guide, optional
+}
+
+type deprecation struct {
+	lastWarned int64 // unix nano, the first field for 64 bit alignment
+	Deprecation
+}
+
+// WithDeprecation marks the route as deprecated, the responses carry the
+// 'Deprecation', 'Sunset' and 'Link' headers, and the calls are logged
+// as warnings
+func WithDeprecation(d Deprecation) RouteOption {
+	return func(r *route) {
+		r.deprecation = &deprecation{Deprecation: d}
+	}
+}
+
+// routeKey returns the key of a route in the route map
+func routeKey(version, name string) string {
+	if len(version) == 0 {
+		return name
+	}
+	return version + "/" + name
+}
+
+// findRoute finds the route of 'name', which may contain a version, or the
+// version selected by VersionHeader. The version in the name takes
+// precedence, and there is no route if the selected version does not
+// exist, so that an argument of one version is never decoded by another.
+func (s *Server) findRoute(name string, r *http.Request) (string, *route) {
+	if v := r.Header.Get(VersionHeader); len(v) > 0 && !strings.HasPrefix(name, v+"/") {
+		name = routeKey(v, name)
+	}
+	return name, s.routes[name]
+}
+
+// setDeprecationHeaders sets the headers of a deprecated route
+func setDeprecationHeaders(w http.ResponseWriter, route *route) {
+	d := route.deprecation
+	if d == nil {
+		return
+	}
+
+	h := w.Header()
+	if d.Since.IsZero() {
+		h.Set("Deprecation", "true")
+	} else {
+		h.Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	}
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if len(d.Link) > 0 {
+		h.Add("Link", "<"+d.Link+`>; rel="deprecation"`)
+	}
+}
+
+// warnDeprecated logs a warning if a deprecated route is called, at most
+// once a minute for every route
+func (s *Server) warnDeprecated(name string, route *route, r *http.Request) {
+	d := route.deprecation
+	if d == nil {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&d.lastWarned)
+	if now-last < int64(time.Minute) || !atomic.CompareAndSwapInt64(&d.lastWarned, last, now) {
+		return
+	}
+
+	logf := s.Logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	logf("WARNING: deprecated route '%s' is called by %s, user agent '%s'", name, KeyByIP(r), r.UserAgent())
+}
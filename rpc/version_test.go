@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Version(t *testing.T) {
+	type echoArgV2 struct {
+		Message string `json:"message"`
+	}
+
+	var logs []string
+	s := NewServer("/api/")
+	s.Logf = func(format string, v ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, v...))
+	}
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Add("POST", "echo", echo, WithDeprecation(Deprecation{Sunset: sunset, Link: "https://example.com/v2"}))
+	s.Add("POST", "echo", func(r *http.Request, arg *echoArgV2) (string, error) {
+		return "v2:" + arg.Message, nil
+	}, WithVersion("v2"))
+
+	call := func(path, version, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if len(version) > 0 {
+			r.Header.Set(VersionHeader, version)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call("/api/echo", "", `{"text":"old"}`)
+	h := w.Header()
+	if !strings.Contains(w.Body.String(), `"data":"old"`) || h.Get("Deprecation") != "true" ||
+		h.Get("Sunset") != "Tue, 01 Jan 2030 00:00:00 GMT" || h.Get("Link") != `<https://example.com/v2>; rel="deprecation"` {
+		t.Errorf("unexpected response %v, %s", h, w.Body.String())
+	}
+	call("/api/echo", "", `{"text":"old"}`)
+	if len(logs) != 1 || !strings.Contains(logs[0], "'echo'") {
+		t.Errorf("unexpected logs: %v", logs)
+	}
+
+	for _, w := range []*httptest.ResponseRecorder{
+		call("/api/v2/echo", "", `{"message":"new"}`),
+		call("/api/echo", "v2", `{"message":"new"}`),
+		call("/api/v2/echo", "v2", `{"message":"new"}`),
+	} {
+		if !strings.Contains(w.Body.String(), `"data":"v2:new"`) || len(w.Header().Get("Deprecation")) > 0 {
+			t.Errorf("unexpected response %v, %s", w.Header(), w.Body.String())
+		}
+	}
+
+	// an unknown version is not served by another version
+	if w := call("/api/echo", "v3", `{"message":"new"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expect status 404, got %d", w.Code)
+	}
+
+	desc := s.Describe()
+	if r := desc.Routes[0]; r.Name != "echo" || !r.Deprecated {
+		t.Errorf("unexpected description: %+v", r)
+	}
+	if r := desc.Routes[1]; r.Name != "v2/echo" || r.Version != "v2" || r.Deprecated {
+		t.Errorf("unexpected description: %+v", r)
+	}
+}
@@ -0,0 +1,303 @@
+package rpc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// this file implements the WebSocket protocol (RFC 6455) used by the
+// WebSocket transport, extensions and subprotocols are not supported
+
+const (
+	wsContinuation = 0
+	wsText         = 1
+	wsBinary       = 2
+	wsClose        = 8
+	wsPing         = 9
+	wsPong         = 10
+)
+
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsWriteTimeout = 10 * time.Second
+)
+
+var (
+	errWSProtocol = errors.New("websocket: protocol error")
+	errWSTooLarge = errors.New("websocket: message too large")
+	errWSClosed   = errors.New("websocket: connection closed")
+)
+
+type wsConn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	client  bool  // frames sent by a client are masked
+	maxSize int64 // maximal size of a message, 0 means no limit
+	timeout time.Duration
+
+	lock   sync.Mutex // serializes writes
+	closed bool
+}
+
+// wsAcceptKey computes the value of the 'Sec-WebSocket-Accept' header
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// randomKey returns 16 random bytes in base64
+func randomKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// headerContains reports whether a comma separated header contains
+// 'token', case insensitively
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsUpgrade upgrades an HTTP request to a WebSocket connection, it writes
+// an error response if the request is not a valid WebSocket handshake
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != "GET" || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return nil, errWSProtocol
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errWSProtocol
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if len(key) == 0 {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return nil, errWSProtocol
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response writer cannot be hijacked")
+	}
+
+	conn, brw, e := hj.Hijack()
+	if e != nil {
+		return nil, e
+	}
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	brw.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if e = brw.Flush(); e != nil {
+		conn.Close()
+		return nil, e
+	}
+
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return errWSClosed
+	}
+
+	var head [14]byte
+	head[0] = 0x80 | op // FIN
+	n := 2
+	switch l := len(payload); {
+	case l < 126:
+		head[1] = byte(l)
+	case l <= 0xffff:
+		head[1] = 126
+		binary.BigEndian.PutUint16(head[2:], uint16(l))
+		n = 4
+	default:
+		head[1] = 127
+		binary.BigEndian.PutUint64(head[2:], uint64(l))
+		n = 10
+	}
+
+	if c.client {
+		head[1] |= 0x80
+		mask := head[n : n+4]
+		rand.Read(mask)
+		n += 4
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, e := c.conn.Write(head[:n]); e != nil {
+		return e
+	}
+	_, e := c.conn.Write(payload)
+	return e
+}
+
+// readFrame reads a frame, 'limit' is the maximal payload size, a negative
+// value means no limit
+func (c *wsConn) readFrame(limit int64) (fin bool, op byte, payload []byte, e error) {
+	if c.timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+
+	var head [8]byte
+	if _, e = io.ReadFull(c.br, head[:2]); e != nil {
+		return
+	}
+
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		// no extension is negotiated, so the reserved bits must be 0
+		e = errWSProtocol
+		return
+	}
+
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		// frames from a client must be masked, and the reverse
+		e = errWSProtocol
+		return
+	}
+
+	size := int64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		if _, e = io.ReadFull(c.br, head[:2]); e != nil {
+			return
+		}
+		size = int64(binary.BigEndian.Uint16(head[:2]))
+	case 127:
+		if _, e = io.ReadFull(c.br, head[:8]); e != nil {
+			return
+		}
+		size = int64(binary.BigEndian.Uint64(head[:8]))
+	}
+
+	if op >= wsClose && (size > 125 || !fin) {
+		e = errWSProtocol
+		return
+	}
+	if size < 0 || (limit >= 0 && size > limit) {
+		e = errWSTooLarge
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, e = io.ReadFull(c.br, mask[:]); e != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, size)
+	if _, e = io.ReadFull(c.br, payload); e != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// readMessage reads a data message, control frames are handled inside,
+// io.EOF is returned if the peer closes the connection
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var msgOp byte
+	var msg []byte
+
+	for {
+		limit := int64(-1)
+		if c.maxSize > 0 {
+			limit = c.maxSize - int64(len(msg))
+		}
+
+		fin, op, payload, e := c.readFrame(limit)
+		if e != nil {
+			if e == errWSTooLarge {
+				c.closeWith(1009)
+			} else if e == errWSProtocol {
+				c.closeWith(1002)
+			}
+			return 0, nil, e
+		}
+
+		switch op {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.closeWith(1000)
+			return 0, nil, io.EOF
+		case wsText, wsBinary:
+			if msgOp != 0 {
+				c.closeWith(1002)
+				return 0, nil, errWSProtocol
+			}
+			msgOp = op
+		case wsContinuation:
+			if msgOp == 0 {
+				c.closeWith(1002)
+				return 0, nil, errWSProtocol
+			}
+		default:
+			c.closeWith(1002)
+			return 0, nil, errWSProtocol
+		}
+
+		msg = append(msg, payload...)
+		if fin {
+			return msgOp, msg, nil
+		}
+	}
+}
+
+// closeWith sends a close frame with 'code' and closes the connection
+func (c *wsConn) closeWith(code uint16) error {
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], code)
+	c.writeFrame(wsClose, payload[:])
+	return c.close()
+}
+
+func (c *wsConn) close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -0,0 +1,255 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WSRequest is a call sent by a client over a WebSocket connection, 'ID'
+// is chosen by the client and must not be 0
+type WSRequest struct {
+	ID    uint64          `json:"id"`
+	Route string          `json:"route"`
+	Arg   json.RawMessage `json:"arg,omitempty"`
+}
+
+// WSMessage is a message sent by the server over a WebSocket connection,
+// it is the result of the call with 'ID', or a notification if 'ID' is 0
+type WSMessage struct {
+	ID     uint64      `json:"id,omitempty"`
+	Result *Result     `json:"result,omitempty"`
+	Event  string      `json:"event,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// WebSocketOptions configures the WebSocket transport of a server
+type WebSocketOptions struct {
+	PingInterval   time.Duration // interval of heartbeats, default is 30s
+	MaxMessageSize int64         // maximal size of a request, default is 1MB
+	MaxPending     int           // maximal concurrent calls of a connection, default is 16
+
+	// CheckOrigin reports whether the origin of the handshake is allowed,
+	// by default, the host of the 'Origin' header must be the host of
+	// the request if there's one
+	CheckOrigin func(r *http.Request) bool
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, e := url.Parse(origin)
+	return e == nil && u.Host == r.Host
+}
+
+// WSConn is a WebSocket connection accepted by a server
+type WSConn struct {
+	ws     *wsConn
+	server *Server
+	req    *http.Request
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type wsConnKey struct{}
+
+// WSConnFromContext returns the WebSocket connection of a call, or nil if
+// the call is not made over a WebSocket connection, handlers can save the
+// connection to push notifications to the client later
+func WSConnFromContext(ctx context.Context) *WSConn {
+	c, _ := ctx.Value(wsConnKey{}).(*WSConn)
+	return c
+}
+
+// Request returns the HTTP request of the handshake
+func (c *WSConn) Request() *http.Request {
+	return c.req
+}
+
+// Done returns a channel which is closed when the connection is closed
+func (c *WSConn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Notify pushes a notification to the client
+func (c *WSConn) Notify(event string, data interface{}) error {
+	return c.send(&WSMessage{Event: event, Data: data})
+}
+
+// Close closes the connection
+func (c *WSConn) Close() error {
+	c.cancel()
+	return c.ws.closeWith(1000)
+}
+
+func (c *WSConn) send(msg *WSMessage) error {
+	data, e := json.Marshal(msg)
+	if e != nil {
+		return e
+	}
+	return c.ws.writeFrame(wsText, data)
+}
+
+// heartbeat pings the client periodically, the connection is closed by
+// the read loop if the client does not respond
+func (c *WSConn) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if c.ws.writeFrame(wsPing, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *WSConn) handle(req *WSRequest) {
+	s := c.server
+	var res interface{}
+	var e error
+
+	if route, ok := s.routes[req.Route]; !ok {
+		msg := fmt.Sprintf("route '%s' not found", req.Route)
+		e = NewError(CodeNotFound, http.StatusNotFound, msg)
+	} else if route.stream {
+		msg := fmt.Sprintf("streaming route '%s' cannot be called over websocket", req.Route)
+		e = NewError(CodeInvalidArgument, http.StatusBadRequest, msg)
+	} else {
+		r := c.req.WithContext(WithTraceID(c.req.Context(), NewTraceID()))
+		res, e = s.call(req.Route, route, r, nil, func(arg interface{}) error {
+			if len(req.Arg) == 0 {
+				return nil
+			}
+			return s.unmarshalJSON(req.Arg, arg)
+		})
+	}
+
+	result, _ := newResult(res, e)
+	c.send(&WSMessage{ID: req.ID, Result: result})
+}
+
+// ServeWebSocket upgrades the request to a WebSocket connection, and then
+// serves the calls sent over the connection with the route table of the
+// server, until the connection is closed. The interceptors of the server
+// are applied, and the request of every call is the HTTP request of the
+// handshake, the method restriction of the routes is not checked.
+// See WSRequest and WSMessage for the messages.
+func (s *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	opts := &s.WebSocket
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "origin is not allowed", http.StatusForbidden)
+		return
+	}
+
+	ws, e := wsUpgrade(w, r)
+	if e != nil {
+		return
+	}
+
+	interval := opts.PingInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ws.maxSize = opts.MaxMessageSize
+	if ws.maxSize <= 0 {
+		ws.maxSize = 1 << 20
+	}
+	ws.timeout = 2 * interval
+
+	c := &WSConn{ws: ws, server: s}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.req = r.WithContext(context.WithValue(c.ctx, wsConnKey{}, c))
+
+	s.addWSConn(c)
+	defer func() {
+		s.removeWSConn(c)
+		c.cancel()
+		ws.close()
+	}()
+
+	go c.heartbeat(interval)
+
+	pending := opts.MaxPending
+	if pending <= 0 {
+		pending = 16
+	}
+	sem := make(chan struct{}, pending)
+
+	for {
+		_, data, e := ws.readMessage()
+		if e != nil {
+			return
+		}
+
+		req := &WSRequest{}
+		if e := json.Unmarshal(data, req); e != nil || req.ID == 0 {
+			msg := "invalid request"
+			if e != nil {
+				msg = e.Error()
+			}
+			result, _ := newResult(nil, NewError(CodeInvalidArgument, http.StatusBadRequest, msg))
+			c.send(&WSMessage{Result: result})
+			continue
+		}
+
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			c.handle(req)
+		}()
+	}
+}
+
+func (s *Server) addWSConn(c *WSConn) {
+	s.wsLock.Lock()
+	defer s.wsLock.Unlock()
+	if s.wsConns == nil {
+		s.wsConns = make(map[*WSConn]struct{})
+	}
+	s.wsConns[c] = struct{}{}
+}
+
+func (s *Server) removeWSConn(c *WSConn) {
+	s.wsLock.Lock()
+	defer s.wsLock.Unlock()
+	delete(s.wsConns, c)
+}
+
+// Notify pushes a notification to all WebSocket connections of the server
+func (s *Server) Notify(event string, data interface{}) {
+	s.wsLock.Lock()
+	conns := make([]*WSConn, 0, len(s.wsConns))
+	for c := range s.wsConns {
+		conns = append(conns, c)
+	}
+	s.wsLock.Unlock()
+
+	for _, c := range conns {
+		c.Notify(event, data)
+	}
+}
+
+// ServeWebSocket serves a WebSocket connection with DefaultServer
+func ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	DefaultServer.ServeWebSocket(w, r)
+}
+
+// Notify pushes a notification to all WebSocket connections of
+// DefaultServer
+func Notify(event string, data interface{}) {
+	DefaultServer.Notify(event, data)
+}
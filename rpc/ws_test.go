@@ -0,0 +1,79 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_WebSocket(t *testing.T) {
+	s := newTestServer("/")
+	s.Add("", "close", func(ctx context.Context) error {
+		return WSConnFromContext(ctx).Close()
+	})
+	ts := httptest.NewServer(http.HandlerFunc(s.ServeWebSocket))
+	defer ts.Close()
+
+	var connects int32
+	events := make(chan string, 1)
+
+	c := NewWSClient("ws" + strings.TrimPrefix(ts.URL, "http"))
+	c.Reconnect = Retry{MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	c.OnConnect = func() { atomic.AddInt32(&connects, 1) }
+	c.OnNotify = func(event string, data json.RawMessage) {
+		events <- event + ":" + string(data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if e := c.Connect(ctx); e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+
+	var res string
+	if e := c.Call(ctx, "echo", &echoArg{Text: "hello"}, &res); e != nil || res != "hello" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+
+	var re *Error
+	if e := c.Call(ctx, "missing", nil, nil); !errors.As(e, &re) || re.Code != CodeNotFound {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	s.Notify("tick", 1)
+	select {
+	case ev := <-events:
+		if ev != "tick:1" {
+			t.Errorf("unexpected notification '%s'", ev)
+		}
+	case <-ctx.Done():
+		t.Fatal("notification is not received")
+	}
+
+	// the server closes the connection, and the client reconnects
+	if e := c.Call(ctx, "close", nil, nil); e == nil {
+		t.Error("expect an error")
+	}
+	if e := c.Call(ctx, "echo", &echoArg{Text: "again"}, &res); e != nil || res != "again" {
+		t.Errorf("unexpected result '%s', %v", res, e)
+	}
+	// OnConnect is called asynchronously
+	for i := 0; i < 100 && atomic.LoadInt32(&connects) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&connects); n != 2 {
+		t.Errorf("expect 2 connections, got %d", n)
+	}
+
+	c.Close()
+	if e := c.Call(ctx, "echo", &echoArg{Text: "x"}, &res); e != ErrClientClosed {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
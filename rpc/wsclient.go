@@ -0,0 +1,362 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrConnectionLost is returned by the pending calls of a WSClient when
+// the connection is lost, the calls are not retried as they may have
+// been executed by the server
+var ErrConnectionLost = errors.New("websocket connection lost")
+
+// ErrClientClosed is returned by the calls of a closed WSClient
+var ErrClientClosed = errors.New("websocket client is closed")
+
+// wsIncoming is WSMessage with raw data
+type wsIncoming struct {
+	ID     uint64          `json:"id"`
+	Result *rawResult      `json:"result"`
+	Event  string          `json:"event"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// WSClient calls routes over a WebSocket connection, see
+// Server.ServeWebSocket. It pings the server periodically, and reconnects
+// with a backoff when the connection is lost.
+type WSClient struct {
+	URL          string        // URL of the server, e.g. 'wss://example.com/ws'
+	Header       http.Header   // headers of the handshake
+	TLSConfig    *tls.Config   // TLS configuration of 'wss' URLs
+	PingInterval time.Duration // interval of heartbeats, default is 30s
+	Reconnect    Retry         // backoff of reconnecting, 'MaxAttempts' is ignored
+
+	// OnConnect is called after every successful connection, e.g. to
+	// subscribe to notifications
+	OnConnect func()
+	// OnNotify is called on every notification, in the read loop
+	OnNotify func(event string, data json.RawMessage)
+
+	nextID  uint64
+	lock    sync.Mutex
+	ws      *wsConn
+	ready   chan struct{} // closed when connected
+	pending map[uint64]chan *wsIncoming
+	done    chan struct{}
+	closed  bool
+}
+
+// NewWSClient creates a new WebSocket client
+func NewWSClient(url string) *WSClient {
+	return &WSClient{URL: url}
+}
+
+// Connect connects to the server, and then keeps the connection in the
+// background until Close is called, it should only be called once
+func (c *WSClient) Connect(ctx context.Context) error {
+	c.ready = make(chan struct{})
+	c.pending = make(map[uint64]chan *wsIncoming)
+	c.done = make(chan struct{})
+
+	ws, e := c.dial(ctx)
+	if e != nil {
+		return e
+	}
+	go c.run(ws)
+	return nil
+}
+
+// Close closes the client, pending calls fail with ErrConnectionLost
+func (c *WSClient) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	ws := c.ws
+	c.lock.Unlock()
+
+	if ws != nil {
+		return ws.closeWith(1000)
+	}
+	return nil
+}
+
+func (c *WSClient) pingInterval() time.Duration {
+	if c.PingInterval > 0 {
+		return c.PingInterval
+	}
+	return 30 * time.Second
+}
+
+// dial connects to the server and performs the handshake
+func (c *WSClient) dial(ctx context.Context) (*wsConn, error) {
+	u, e := url.Parse(c.URL)
+	if e != nil {
+		return nil, e
+	}
+
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme, secure = "https", true
+	default:
+		return nil, errors.New("websocket: unsupported scheme '" + u.Scheme + "'")
+	}
+
+	addr := u.Host
+	if len(u.Port()) == 0 {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	var d net.Dialer
+	conn, e := d.DialContext(ctx, "tcp", addr)
+	if e != nil {
+		return nil, e
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if secure {
+		cfg := c.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if len(cfg.ServerName) == 0 {
+			cfg = cfg.Clone()
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(conn, cfg)
+		if e = tc.Handshake(); e != nil {
+			conn.Close()
+			return nil, e
+		}
+		conn = tc
+	}
+
+	key := randomKey()
+	req := &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if e = req.Write(conn); e != nil {
+		conn.Close()
+		return nil, e
+	}
+
+	br := bufio.NewReader(conn)
+	resp, e := http.ReadResponse(br, req)
+	if e != nil {
+		conn.Close()
+		return nil, e
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, &Error{Status: resp.StatusCode, Message: resp.Status}
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		conn.Close()
+		return nil, errWSProtocol
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, br: br, client: true, timeout: 2 * c.pingInterval()}, nil
+}
+
+// run serves the connection, and reconnects when it is lost
+func (c *WSClient) run(ws *wsConn) {
+	for {
+		c.serve(ws)
+
+		for i := 1; ; i++ {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(c.Reconnect.backoff(i)):
+			}
+
+			var e error
+			if ws, e = c.dial(context.Background()); e == nil {
+				break
+			}
+		}
+	}
+}
+
+// serve reads the messages from the connection until it is lost
+func (c *WSClient) serve(ws *wsConn) {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		ws.close()
+		return
+	}
+	c.ws = ws
+	close(c.ready)
+	c.lock.Unlock()
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(c.pingInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ws.writeFrame(wsPing, nil)
+			}
+		}
+	}()
+
+	if c.OnConnect != nil {
+		go c.OnConnect()
+	}
+
+	for {
+		_, data, e := ws.readMessage()
+		if e != nil {
+			break
+		}
+
+		msg := &wsIncoming{}
+		if json.Unmarshal(data, msg) != nil {
+			continue
+		}
+		if msg.ID == 0 {
+			if len(msg.Event) > 0 && c.OnNotify != nil {
+				c.OnNotify(msg.Event, msg.Data)
+			}
+			continue
+		}
+
+		c.lock.Lock()
+		ch := c.pending[msg.ID]
+		delete(c.pending, msg.ID)
+		c.lock.Unlock()
+		if ch != nil {
+			ch <- msg
+		}
+	}
+
+	close(stop)
+	ws.close()
+
+	c.lock.Lock()
+	c.ws = nil
+	c.ready = make(chan struct{})
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.lock.Unlock()
+}
+
+// Call calls 'route' with 'arg', and decodes the result into 'result', it
+// waits for the connection if the client is reconnecting
+func (c *WSClient) Call(ctx context.Context, route string, arg, result interface{}) error {
+	c.lock.Lock()
+	c.nextID++
+	req := &WSRequest{ID: c.nextID, Route: route}
+	c.lock.Unlock()
+
+	if arg != nil {
+		data, e := json.Marshal(arg)
+		if e != nil {
+			return e
+		}
+		req.Arg = data
+	}
+	data, e := json.Marshal(req)
+	if e != nil {
+		return e
+	}
+
+	ch := make(chan *wsIncoming, 1)
+	ws, e := c.register(ctx, req.ID, ch)
+	if e != nil {
+		return e
+	}
+	if e := ws.writeFrame(wsText, data); e != nil {
+		c.unregister(req.ID)
+		return e
+	}
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return ErrConnectionLost
+		}
+		if msg.Result == nil {
+			return errWSProtocol
+		}
+		return msg.Result.decode(result, 0)
+	case <-ctx.Done():
+		c.unregister(req.ID)
+		return ctx.Err()
+	}
+}
+
+// register waits for the connection, and then registers a pending call
+func (c *WSClient) register(ctx context.Context, id uint64, ch chan *wsIncoming) (*wsConn, error) {
+	for {
+		c.lock.Lock()
+		if c.closed {
+			c.lock.Unlock()
+			return nil, ErrClientClosed
+		}
+		ws, ready := c.ws, c.ready
+		if ws != nil {
+			c.pending[id] = ch
+		}
+		c.lock.Unlock()
+
+		if ws != nil {
+			return ws, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, ErrClientClosed
+		case <-ready:
+		}
+	}
+}
+
+func (c *WSClient) unregister(id uint64) {
+	c.lock.Lock()
+	delete(c.pending, id)
+	c.lock.Unlock()
+}